@@ -0,0 +1,115 @@
+package mdssr
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Middleware wraps a handler, for example to log, authenticate or trace requests.
+type Middleware func(http.Handler) http.Handler
+
+// chain wraps h in middleware, the first one outermost.
+func chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// newAccessLogger returns a logger writing access logs to standard error in
+// format, text or json.
+func newAccessLogger(format string) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil)), nil
+	}
+	return nil, fmt.Errorf("unknown access log format %q, expected text or json", format)
+}
+
+// accessLog returns middleware logging every request to logger once it is answered.
+func accessLog(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("host", r.Host),
+				slog.String("path", r.URL.RequestURI()),
+				slog.Int("status", sw.status),
+				slog.Duration("duration", time.Since(start)),
+				slog.Int64("bytes", sw.bytes),
+				slog.String("remote", r.RemoteAddr),
+			)
+		})
+	}
+}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+// Flush sends what has been written so far, for streams such as live reload.
+func (sw *statusWriter) Flush() {
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// serverHandler returns the handler serving every request in any mode: the
+// default mux wrapped in the middleware cfg asks for.
+func serverHandler(cfg Config) (http.Handler, error) {
+	var middleware []Middleware
+	if cfg.Metrics {
+		middleware = append(middleware, metrics.countRequests)
+	}
+	if cfg.AccessLog != "" {
+		logger, err := newAccessLogger(cfg.AccessLog)
+		if err != nil {
+			return nil, err
+		}
+		middleware = append(middleware, accessLog(logger))
+	}
+	// Rejected requests are still logged and counted, but never compressed
+	if cfg.MaxURL > 0 || cfg.MaxBody > 0 {
+		middleware = append(middleware, limitRequests(cfg.MaxURL, cfg.MaxBody))
+	}
+	if cfg.RateLimit > 0 {
+		middleware = append(middleware, newRateLimiter(cfg.RateLimit, cfg.RateBurst, cfg.TrustProxy).middleware)
+	}
+	if cfg.Compress {
+		middleware = append(middleware, compressHandler)
+	}
+	return chain(http.DefaultServeMux, middleware...), nil
+}
@@ -0,0 +1,91 @@
+package mdssr
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Paths of the admin endpoints, enabled with --admin-token.
+const (
+	adminPurgePath    = "/_admin/purge"
+	adminPurgeAllPath = "/_admin/purge-all"
+)
+
+// purgeTargets are the sites the admin endpoints purge, one per mount.
+var purgeTargets struct {
+	mu    sync.Mutex
+	sites []*siteHandler
+}
+
+// registerPurgeTarget makes the admin endpoints purge site.
+func registerPurgeTarget(site *siteHandler) {
+	purgeTargets.mu.Lock()
+	defer purgeTargets.mu.Unlock()
+	purgeTargets.sites = append(purgeTargets.sites, site)
+}
+
+// adminHandler serves the admin endpoints to clients sending the token as a
+// bearer token. Both take POST requests: /_admin/purge?path=/page.md drops the
+// rendered page at path, or the pages under it for a directory, and
+// /_admin/purge-all every page. Either way, the indexes of the sites are
+// rebuilt from the tree on next use.
+type adminHandler struct {
+	token string
+}
+
+func (a adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+a.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	urlPath := r.URL.Query().Get("path")
+	if r.URL.Path == adminPurgePath && !strings.HasPrefix(urlPath, "/") {
+		http.Error(w, "Bad Request: path must start with /", http.StatusBadRequest)
+		return
+	}
+
+	purged := 0
+	purgeTargets.mu.Lock()
+	for _, site := range purgeTargets.sites {
+		h := site.current.Load()
+		if r.URL.Path == adminPurgeAllPath {
+			purged += h.purge("/")
+		} else if rest, ok := strings.CutPrefix(urlPath, h.prefix); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+			purged += h.purge(rest)
+		}
+	}
+	purgeTargets.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Purged int `json:"purged"`
+	}{purged})
+}
+
+// purge drops the rendered pages at urlPath, a path below the mount point, or
+// under it, and marks the indexes of the tree for rebuilding. Markdown
+// extensions may be left out. It returns the number of pages dropped.
+func (h *markdownHandler) purge(urlPath string) int {
+	defer h.contentChanged()
+	if h.cache == nil {
+		return 0
+	}
+	path, err := sanitizePath(h.basePath, filepath.Join(h.basePath, filepath.FromSlash(urlPath)))
+	if err != nil {
+		return 0
+	}
+	return h.cache.remove(func(file string) bool {
+		return isWithin(path, file) || strings.TrimSuffix(file, h.cfg.markdownExt(file)) == path
+	})
+}
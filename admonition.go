@@ -0,0 +1,172 @@
+package mdssr
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindAdmonition is the node kind of admonitions in the AST.
+var KindAdmonition = ast.NewNodeKind("Admonition")
+
+// admonition is a callout such as a note or a warning.
+type admonition struct {
+	ast.BaseBlock
+	// Variant is the lowercase kind of callout, such as note, used as its class.
+	Variant string
+	Title   string
+}
+
+func (n *admonition) Kind() ast.NodeKind {
+	return KindAdmonition
+}
+
+func (n *admonition) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Variant": n.Variant, "Title": n.Title}, nil)
+}
+
+// newAdmonition returns an admonition of the given type, titled after the type
+// unless title is given.
+func newAdmonition(kind, title string) *admonition {
+	kind = strings.ToLower(kind)
+	if title = strings.TrimSpace(title); title == "" {
+		title = strings.ToUpper(kind[:1]) + kind[1:]
+	}
+	return &admonition{Variant: kind, Title: title}
+}
+
+// admonitionExtension renders GitHub style alerts, blockquotes starting with a
+// line like [!NOTE], and :::note containers as admonitions.
+type admonitionExtension struct{}
+
+func (admonitionExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(admonitionParser{}, 150)),
+		parser.WithASTTransformers(util.Prioritized(alertTransformer{}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(admonitionRenderer{}, 150)))
+}
+
+// containerPattern matches the opening line of a container: :::type and an optional title.
+var containerPattern = regexp.MustCompile(`^:::[ \t]*([A-Za-z][A-Za-z0-9-]*)[ \t]*(.*?)[ \t]*$`)
+
+// admonitionParser parses containers from a :::type line to a ::: line.
+// Containers don't nest.
+type admonitionParser struct{}
+
+func (admonitionParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (admonitionParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	m := containerPattern.FindSubmatch(util.TrimRightSpace(line[pos:]))
+	if m == nil {
+		return nil, parser.NoChildren
+	}
+	reader.Advance(segment.Len() - 1)
+	return newAdmonition(string(m[1]), string(m[2])), parser.HasChildren
+}
+
+func (admonitionParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	if line == nil {
+		return parser.Close
+	}
+	if string(util.TrimRightSpace(util.TrimLeftSpace(line))) == ":::" {
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+	return parser.Continue | parser.HasChildren
+}
+
+func (admonitionParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (admonitionParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (admonitionParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// alertPattern matches the first line of a GitHub style alert, with an optional
+// title as Obsidian allows.
+var alertPattern = regexp.MustCompile(`^\[!([A-Za-z][A-Za-z0-9-]*)\][+-]?[ \t]*(.*)$`)
+
+// alertTransformer turns blockquotes whose first line is like [!NOTE] into admonitions.
+type alertTransformer struct{}
+
+func (alertTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	var quotes []*ast.Blockquote
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if quote, ok := n.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, quote)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	source := reader.Source()
+	for _, quote := range quotes {
+		para, ok := quote.FirstChild().(*ast.Paragraph)
+		if !ok || para.Lines().Len() == 0 {
+			continue
+		}
+		first := para.Lines().At(0)
+		m := alertPattern.FindSubmatch(bytes.TrimRight(first.Value(source), " \t\r\n"))
+		if m == nil {
+			continue
+		}
+
+		node := newAdmonition(string(m[1]), string(m[2]))
+		// Drop the inlines of the first line, which end at its line break
+		for c := para.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			para.RemoveChild(para, c)
+			if t, ok := c.(*ast.Text); ok && (t.SoftLineBreak() || t.HardLineBreak()) {
+				break
+			}
+			c = next
+		}
+		if !para.HasChildren() {
+			quote.RemoveChild(quote, para)
+		}
+
+		for c := quote.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			node.AppendChild(node, c)
+			c = next
+		}
+		quote.Parent().ReplaceChild(quote.Parent(), quote, node)
+	}
+}
+
+// admonitionRenderer writes admonitions as divs with the "admonition" class and
+// their type as classes, led by their title.
+type admonitionRenderer struct{}
+
+func (admonitionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindAdmonition, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			_, _ = w.WriteString("</div>\n")
+			return ast.WalkContinue, nil
+		}
+		node := n.(*admonition)
+		_, _ = w.WriteString(`<div class="admonition ` + node.Variant + `">` + "\n")
+		_, _ = w.WriteString(`<p class="admonition-title">`)
+		_, _ = w.Write(util.EscapeHTML([]byte(node.Title)))
+		_, _ = w.WriteString("</p>\n")
+		return ast.WalkContinue, nil
+	})
+}
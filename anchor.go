@@ -0,0 +1,79 @@
+package mdssr
+
+import (
+	"html"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// anchorScript is the URL of the embedded script copying the link of a heading
+// anchor as it is clicked.
+const anchorScript = assetsPrefix + "anchors.js"
+
+// KindHeadingAnchor is the node kind of the permalink of a heading in the AST.
+var KindHeadingAnchor = ast.NewNodeKind("HeadingAnchor")
+
+// headingAnchor is the permalink appended to a heading, linking to its id.
+type headingAnchor struct {
+	ast.BaseInline
+	id []byte
+}
+
+func (n *headingAnchor) Kind() ast.NodeKind {
+	return KindHeadingAnchor
+}
+
+func (n *headingAnchor) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"ID": string(n.id)}, nil)
+}
+
+// anchorExtension appends a permalink to every heading, using the id given by
+// the TOC extension's slugger, so links to sections match the TOC.
+type anchorExtension struct{}
+
+func (anchorExtension) Extend(m goldmark.Markdown) {
+	// After the TOC, which takes the text of the headings as they are written
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(anchorTransformer{}, 300)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(anchorRenderer{}, 200)))
+}
+
+// anchorTransformer appends a headingAnchor to each heading with an id.
+type anchorTransformer struct{}
+
+func (anchorTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	found := false
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if id, ok := heading.AttributeString("id"); ok {
+			if id, ok := id.([]byte); ok && len(id) > 0 {
+				heading.AppendChild(heading, &headingAnchor{id: id})
+				found = true
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	if found {
+		addPageAssets(pc, nil, []string{anchorScript})
+	}
+}
+
+// anchorRenderer writes headingAnchor nodes.
+type anchorRenderer struct{}
+
+func (anchorRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindHeadingAnchor, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			id := html.EscapeString(string(n.(*headingAnchor).id))
+			_, _ = w.WriteString(` <a class="anchor" href="#` + id + `" aria-label="Link to this section">#</a>`)
+		}
+		return ast.WalkContinue, nil
+	})
+}
@@ -0,0 +1,61 @@
+package mdssr
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// pageJSON is a rendered page as served to clients asking for JSON, such as
+// single page apps doing their own layout.
+type pageJSON struct {
+	URL         string         `json:"url"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Content     string         `json:"content"`
+	TOC         string         `json:"toc,omitempty"`
+	CSS         []string       `json:"css,omitempty"`
+	JS          []string       `json:"js,omitempty"`
+	Head        string         `json:"head,omitempty"`
+	FrontMatter map[string]any `json:"frontMatter"`
+	ModTime     time.Time      `json:"modTime"`
+}
+
+// wantsJSON reports whether the client asks for JSON with an Accept header or format=json.
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// servePageJSON writes page, rendered from the file at path, as JSON. The
+// page-specific assets are included, as the content may depend on them.
+func (h *markdownHandler) servePageJSON(w http.ResponseWriter, r *http.Request, path string, info os.FileInfo, page *renderedPage) {
+	data := pageJSON{
+		URL:         h.prefix + r.URL.Path,
+		Title:       page.Title,
+		Description: page.Meta.Description,
+		Content:     string(page.Content),
+		TOC:         string(page.TOC),
+		CSS:         h.assetURLs(slices.Clone(page.Assets.CSS)),
+		JS:          h.assetURLs(slices.Clone(page.Assets.JS)),
+		Head:        string(page.Head),
+		FrontMatter: page.Params,
+		ModTime:     info.ModTime().UTC(),
+	}
+	if data.FrontMatter == nil {
+		data.FrontMatter = map[string]any{}
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(data); err != nil {
+		h.serverError(w, r, "Error encoding page")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// The HTML and JSON of a page are different representations of the same URL
+	w.Header().Set("ETag", strings.TrimSuffix(h.etag(r, path, info, page.Includes...), `"`)+`-json"`)
+	http.ServeContent(w, r, path, h.lastModified(info), bytes.NewReader(buf.Bytes()))
+}
@@ -0,0 +1,143 @@
+package mdssr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isArchive reports whether path names a bundle of the tree, served in place of a directory.
+func isArchive(path string) bool {
+	name := strings.ToLower(path)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// openSource returns the directory to serve for path, which is either a directory
+// or an archive. Archives are extracted to a temporary directory, which cleanup
+// removes. A single directory at the top of an archive is served as its root.
+func openSource(path string) (dir string, cleanup func(), err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !isArchive(abs) {
+		return abs, func() {}, nil
+	}
+
+	if strings.HasSuffix(strings.ToLower(abs), ".zip") {
+		r, err := zip.OpenReader(abs)
+		if err != nil {
+			return "", nil, err
+		}
+		defer r.Close()
+		dir, err = extractFS(r)
+	} else {
+		dir, err = extractTarGz(abs)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("extracting %s: %w", path, err)
+	}
+	tmp := dir
+	cleanup = func() { os.RemoveAll(tmp) }
+
+	entries, err := os.ReadDir(dir)
+	if err == nil && len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), cleanup, nil
+	}
+	return dir, cleanup, nil
+}
+
+// fatalfFor returns a function logging like log.Fatalf that calls cleanup
+// first, as the deferred calls removing an extracted archive don't run when
+// the process exits.
+func fatalfFor(cleanup func()) func(format string, v ...any) {
+	return func(format string, v ...any) {
+		cleanup()
+		log.Fatalf(format, v...)
+	}
+}
+
+// extractFS copies fsys to a new temporary directory and returns its path.
+func extractFS(fsys fs.FS) (string, error) {
+	dir, err := os.MkdirTemp("", "mdssr-")
+	if err != nil {
+		return "", err
+	}
+	if err := os.CopyFS(dir, fsys); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// extractTarGz extracts the directories and regular files of the gzipped tar
+// archive at path to a new temporary directory and returns its path. Links and
+// entries reaching outside the archive are skipped.
+func extractTarGz(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+
+	dir, err := os.MkdirTemp("", "mdssr-")
+	if err != nil {
+		return "", err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return dir, nil
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		name := filepath.FromSlash(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "" || !filepath.IsLocal(name) {
+			continue
+		}
+		target := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0o755)
+		case tar.TypeReg:
+			err = extractFile(target, tr)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+}
+
+// extractFile writes the contents of r to a new file at path, creating its directory.
+func extractFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,81 @@
+package mdssr
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// embeddedAssets holds the static files that ship with the binary.
+//
+//go:embed assets
+var embeddedAssets embed.FS
+
+// assetsPrefix is the URL prefix under which the embedded assets are served.
+const assetsPrefix = "/_assets/"
+
+// colorSchemeScript is the URL of the embedded script behind the color scheme toggle.
+const colorSchemeScript = assetsPrefix + "color-scheme.js"
+
+// themesDir is the directory of the embedded assets that holds the built-in themes.
+const themesDir = "themes"
+
+// assetsFS returns the embedded assets rooted at the assets directory.
+func assetsFS() fs.FS {
+	sub, err := fs.Sub(embeddedAssets, "assets")
+	if err != nil {
+		// The directory is embedded at build time, so this cannot fail
+		panic(err)
+	}
+	return sub
+}
+
+// assetsHandler serves the embedded assets under assetsPrefix.
+func assetsHandler() http.Handler {
+	return http.StripPrefix(assetsPrefix, http.FileServer(http.FS(assetsFS())))
+}
+
+// writeAssets copies the embedded assets into dir.
+func writeAssets(dir string) error {
+	assets := assetsFS()
+	return fs.WalkDir(assets, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		data, err := fs.ReadFile(assets, path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o644)
+	})
+}
+
+// themeNames returns the names of the built-in themes.
+func themeNames() []string {
+	entries, _ := fs.ReadDir(assetsFS(), themesDir)
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".css"); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// themeURL returns the URL of the stylesheet of the built-in theme called name.
+func themeURL(name string) (string, error) {
+	file := path.Join(themesDir, name+".css")
+	if _, err := fs.Stat(assetsFS(), file); err != nil {
+		return "", fmt.Errorf("unknown theme %q, available themes: %s", name, strings.Join(themeNames(), ", "))
+	}
+	return assetsPrefix + file, nil
+}
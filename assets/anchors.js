@@ -0,0 +1,22 @@
+(function () {
+    // copy puts the link of anchor on the clipboard, marking it as copied for a moment.
+    function copy(anchor) {
+        if (!navigator.clipboard) {
+            return;
+        }
+        navigator.clipboard.writeText(anchor.href).then(function () {
+            anchor.classList.add("copied");
+            setTimeout(function () {
+                anchor.classList.remove("copied");
+            }, 1500);
+        }, function () {});
+    }
+
+    // The click still follows the link, so the address bar shows it too
+    document.addEventListener("click", function (event) {
+        var anchor = event.target.closest && event.target.closest("a.anchor");
+        if (anchor) {
+            copy(anchor);
+        }
+    });
+})();
@@ -0,0 +1,49 @@
+(function () {
+    var key = "mdssr-color-scheme";
+    var root = document.documentElement;
+
+    // apply sets the color scheme the themes and the browser render the page with.
+    function apply(scheme) {
+        root.setAttribute("data-color-scheme", scheme);
+        root.style.colorScheme = scheme;
+        var meta = document.querySelector('meta[name="color-scheme"]');
+        if (meta) {
+            meta.content = scheme;
+        }
+    }
+
+    // current returns the color scheme the page is rendered with.
+    function current() {
+        return root.getAttribute("data-color-scheme") ||
+            (window.matchMedia("(prefers-color-scheme: dark)").matches ? "dark" : "light");
+    }
+
+    // The choice made with the toggle takes precedence over the site's default,
+    // and is applied before the body renders so the page doesn't flash
+    var stored = null;
+    try {
+        stored = localStorage.getItem(key);
+    } catch (e) {}
+    if (stored === "light" || stored === "dark") {
+        apply(stored);
+    }
+
+    function init() {
+        document.querySelectorAll(".color-scheme-toggle").forEach(function (button) {
+            button.hidden = false;
+            button.addEventListener("click", function () {
+                var scheme = current() === "dark" ? "light" : "dark";
+                apply(scheme);
+                try {
+                    localStorage.setItem(key, scheme);
+                } catch (e) {}
+            });
+        });
+    }
+
+    if (document.readyState === "loading") {
+        document.addEventListener("DOMContentLoaded", init);
+    } else {
+        init();
+    }
+})();
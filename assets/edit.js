@@ -0,0 +1,89 @@
+(function () {
+    // init wires up the editor in form: the preview follows the textarea, and
+    // submitting saves the page with PUT.
+    function init(form) {
+        var source = form.querySelector("textarea");
+        var preview = form.querySelector(".editor-preview");
+        var status = form.querySelector(".editor-status");
+        var version = form.dataset.version;
+        var timer = null;
+        // The server only takes markdown sent as such, which other sites can't send
+        var contentType = "text/markdown; charset=utf-8";
+        var saved = source.value;
+        // The preview frame is sandboxed, so it only gets the stylesheets of the editor
+        var styles = Array.prototype.map.call(document.querySelectorAll('link[rel="stylesheet"]'), function (link) {
+            return link.outerHTML;
+        }).join("");
+
+        function report(message) {
+            status.textContent = message;
+        }
+
+        function refresh() {
+            fetch(form.dataset.save, { method: "POST", headers: { "Content-Type": contentType }, body: source.value })
+                .then(function (res) {
+                    return res.text().then(function (text) {
+                        if (!res.ok) {
+                            throw new Error(text.trim() || res.statusText);
+                        }
+                        preview.srcdoc = "<!DOCTYPE html><html><head>" + styles + "</head><body>" + text + "</body></html>";
+                    });
+                })
+                .catch(function (err) {
+                    report("Preview failed: " + err.message);
+                });
+        }
+
+        source.addEventListener("input", function () {
+            report(source.value === saved ? "" : "Unsaved changes");
+            clearTimeout(timer);
+            timer = setTimeout(refresh, 300);
+        });
+
+        form.addEventListener("submit", function (event) {
+            event.preventDefault();
+            var headers = { "Content-Type": contentType };
+            // New pages must not exist yet, and others be unchanged since they were opened
+            if (version) {
+                headers["If-Match"] = version;
+            } else {
+                headers["If-None-Match"] = "*";
+            }
+            var text = source.value;
+            report("Saving...");
+            fetch(form.dataset.save, { method: "PUT", headers: headers, body: text })
+                .then(function (res) {
+                    if (res.status === 412) {
+                        throw new Error("the page changed since it was opened, reload to get the latest version");
+                    }
+                    if (!res.ok) {
+                        throw new Error(res.statusText);
+                    }
+                    version = res.headers.get("ETag") || version;
+                    saved = text;
+                    report("Saved");
+                })
+                .catch(function (err) {
+                    report("Save failed: " + err.message);
+                });
+        });
+
+        window.addEventListener("beforeunload", function (event) {
+            if (source.value !== saved) {
+                event.preventDefault();
+            }
+        });
+
+        refresh();
+    }
+
+    function start() {
+        document.querySelectorAll("form.editor").forEach(init);
+    }
+
+    if (document.readyState === "loading") {
+        document.addEventListener("DOMContentLoaded", start);
+    } else {
+        start();
+    }
+})();
@@ -0,0 +1,16 @@
+(function () {
+    function render() {
+        document.querySelectorAll(".math").forEach(function (el) {
+            katex.render(el.textContent, el, {
+                displayMode: el.classList.contains("display"),
+                throwOnError: false
+            });
+        });
+    }
+
+    if (document.readyState === "loading") {
+        document.addEventListener("DOMContentLoaded", render);
+    } else {
+        render();
+    }
+})();
@@ -0,0 +1,198 @@
+package mdssr
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// accessFileName is the per-directory file restricting access to a subtree.
+const accessFileName = ".access"
+
+// authRealm is the realm sent in basic auth challenges.
+const authRealm = "mdssr"
+
+// AccessRule controls who may read a subtree.
+// Rules come from .access files and the access section of the config file;
+// the most specific rule for a path applies.
+type AccessRule struct {
+	// Users lists the users allowed in; empty means any authenticated user.
+	Users []string `yaml:"users"`
+	// Public allows everyone in without authenticating.
+	Public bool `yaml:"public"`
+
+	deny bool
+}
+
+// authenticator checks basic auth credentials against the configured users.
+type authenticator struct {
+	basePath string
+	// users maps user names to passwords, either in plain text (from --basic-auth)
+	// or as bcrypt or {SHA} hashes (from an htpasswd file).
+	users  map[string]string
+	hashed map[string]bool
+	rules  map[string]AccessRule
+}
+
+// newAuthenticator returns an authenticator for cfg, or nil if no users are configured.
+func newAuthenticator(basePath string, cfg Config) (*authenticator, error) {
+	a := &authenticator{
+		basePath: basePath,
+		users:    make(map[string]string),
+		hashed:   make(map[string]bool),
+		rules:    make(map[string]AccessRule),
+	}
+
+	for _, cred := range cfg.BasicAuth {
+		user, pass, ok := strings.Cut(cred, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid basic auth credentials %q, expected user:pass", cred)
+		}
+		a.users[user] = pass
+	}
+
+	if cfg.Htpasswd != "" {
+		if err := a.loadHtpasswd(cfg.Htpasswd); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(a.users) == 0 {
+		return nil, nil
+	}
+
+	for prefix, rule := range cfg.Access {
+		a.rules[path.Clean("/"+prefix)] = rule
+	}
+	return a, nil
+}
+
+// loadHtpasswd reads user:hash lines from an htpasswd file.
+// Only bcrypt and {SHA} hashes are supported.
+func (a *authenticator) loadHtpasswd(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("%s:%d: expected user:hash", file, line)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return fmt.Errorf("%s:%d: unsupported hash for user %s, use bcrypt (htpasswd -B)", file, line, user)
+		}
+		a.users[user] = hash
+		a.hashed[user] = true
+	}
+	return scanner.Err()
+}
+
+// authenticate returns the user whose valid credentials the request carries.
+func (a *authenticator) authenticate(r *http.Request) (string, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	stored, ok := a.users[user]
+	if !ok {
+		return "", false
+	}
+
+	switch {
+	case !a.hashed[user]:
+		ok = subtle.ConstantTimeCompare([]byte(pass), []byte(stored)) == 1
+	case strings.HasPrefix(stored, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		ok = subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(stored[len("{SHA}"):])) == 1
+	default:
+		ok = bcrypt.CompareHashAndPassword([]byte(stored), []byte(pass)) == nil
+	}
+	return user, ok
+}
+
+// ruleFor returns the most specific access rule for urlPath, checking each
+// enclosing directory from the root down. Within a directory, its .access file
+// takes precedence over the config file.
+func (a *authenticator) ruleFor(urlPath string) AccessRule {
+	var rule AccessRule
+	prefix := "/"
+	for _, segment := range strings.Split(path.Clean("/"+urlPath), "/") {
+		prefix = path.Join(prefix, segment)
+		if r, ok := a.rules[prefix]; ok {
+			rule = r
+		}
+		if r, ok := a.readAccessFile(prefix); ok {
+			rule = r
+		}
+	}
+	return rule
+}
+
+// admits reports whether the rule lets in everyone the other rule lets in.
+func (rule AccessRule) admits(other AccessRule) bool {
+	switch {
+	case rule.Public || other.deny:
+		return true
+	case rule.deny || other.Public:
+		return false
+	case len(rule.Users) == 0:
+		return true
+	case len(other.Users) == 0:
+		return false
+	}
+	return !slices.ContainsFunc(other.Users, func(user string) bool { return !slices.Contains(rule.Users, user) })
+}
+
+// readAccessFile reads the .access file of the directory at urlPath, if there is one.
+func (a *authenticator) readAccessFile(urlPath string) (AccessRule, bool) {
+	var rule AccessRule
+	data, err := os.ReadFile(filepath.Join(a.basePath, filepath.FromSlash(urlPath), accessFileName))
+	if err != nil {
+		return rule, false
+	}
+	if err := yaml.Unmarshal(data, &rule); err != nil {
+		// Fail closed rather than opening the directory up
+		log.Printf("Error parsing %s in %s: %v\n", accessFileName, urlPath, err)
+		return AccessRule{deny: true}, true
+	}
+	return rule, true
+}
+
+// allowed reports whether the request may read urlPath.
+func (a *authenticator) allowed(r *http.Request, urlPath string) bool {
+	rule := a.ruleFor(urlPath)
+	if rule.Public {
+		return true
+	}
+	user, ok := a.authenticate(r)
+	if !ok || rule.deny {
+		return false
+	}
+	return len(rule.Users) == 0 || slices.Contains(rule.Users, user)
+}
+
+// challenge asks the client for credentials.
+func challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+authRealm+`", charset="UTF-8"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
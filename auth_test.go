@@ -0,0 +1,124 @@
+package mdssr
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuth(t *testing.T) {
+	base := t.TempDir()
+	writeTree(t, base, "index.md", "team/page.md", "team/open/page.md", "ops/page.md", "ops/open/page.md", "broken/page.md")
+	writeFiles(t, base, map[string]string{
+		"team/" + accessFileName:      "users: [alice]\n",
+		"team/open/" + accessFileName: "public: true\n",
+		"ops/open/" + accessFileName:  "users: [alice, bob]\n",
+		"broken/" + accessFileName:    "users: [alice\n",
+	})
+	bcrypted, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte("secret"))
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	entries := "# users\ncarol:" + string(bcrypted) + "\ndave:{SHA}" + base64.StdEncoding.EncodeToString(sum[:]) + "\n"
+	if err := os.WriteFile(htpasswd, []byte(entries), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.BasicAuth = []string{"alice:secret", "bob:secret"}
+	cfg.Htpasswd = htpasswd
+	cfg.Access = map[string]AccessRule{"ops": {Users: []string{"bob"}}}
+	h, err := newMarkdownHandler(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		user   string
+		status int
+	}{
+		{"/index.md", "", http.StatusUnauthorized},
+		{"/index.md", "mallory", http.StatusUnauthorized},
+		{"/index.md", "alice", http.StatusOK},
+		{"/index.md", "carol", http.StatusOK},
+		{"/index.md", "dave", http.StatusOK},
+		{"/team/page.md", "bob", http.StatusUnauthorized},
+		{"/team/page.md", "alice", http.StatusOK},
+		{"/team/open/page.md", "", http.StatusOK},
+		{"/team/" + accessFileName, "alice", http.StatusNotFound},
+		// The access section of the config file, and .access files below it
+		{"/ops/page.md", "alice", http.StatusUnauthorized},
+		{"/ops/page.md", "bob", http.StatusOK},
+		{"/ops/open/page.md", "alice", http.StatusOK},
+		// A .access file that doesn't parse locks everyone out
+		{"/broken/page.md", "alice", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" as "+tt.user, func(t *testing.T) {
+			w := get(h, tt.path, tt.user)
+			if w.Code != tt.status {
+				t.Fatalf("GET %s as %q = %d, want %d", tt.path, tt.user, w.Code, tt.status)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); (tt.status == http.StatusUnauthorized) != strings.HasPrefix(challenge, "Basic ") {
+				t.Errorf("GET %s as %q has WWW-Authenticate %q", tt.path, tt.user, challenge)
+			}
+			if tt.status == http.StatusOK && w.Header().Get("Cache-Control") != "private" {
+				t.Errorf("GET %s as %q has Cache-Control %q, want private", tt.path, tt.user, w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+
+	t.Run("wrong password", func(t *testing.T) {
+		for _, user := range []string{"alice", "carol", "dave"} {
+			r := httptest.NewRequest(http.MethodGet, "/index.md", nil)
+			r.SetBasicAuth(user, "wrong")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("GET /index.md as %s with a wrong password = %d, want %d", user, w.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	dir := t.TempDir()
+	htpasswd := filepath.Join(dir, "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte("erin:$apr1$salt$hash\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		basicAuth []string
+		htpasswd  string
+	}{
+		{"no password separator", []string{"alice"}, ""},
+		{"no user", []string{":secret"}, ""},
+		{"unsupported hash", nil, htpasswd},
+		{"missing htpasswd", nil, filepath.Join(dir, "missing")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.BasicAuth = tt.basicAuth
+			cfg.Htpasswd = tt.htpasswd
+			if _, err := newAuthenticator(dir, cfg); err == nil {
+				t.Error("newAuthenticator succeeded, want an error")
+			}
+		})
+	}
+
+	if a, err := newAuthenticator(dir, DefaultConfig()); a != nil || err != nil {
+		t.Errorf("newAuthenticator without users = %v, %v, want nil, nil", a, err)
+	}
+}
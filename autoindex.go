@@ -0,0 +1,136 @@
+package mdssr
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Template for the auto-generated directory listing.
+// It is rendered into the Content field of the page template.
+const autoIndexTemplate = `<style>
+    .autoindex { list-style: none; padding: 0; }
+    .autoindex li { padding: 0.25em 0; border-bottom: 1px solid #eee; }
+    .autoindex a { text-decoration: none; }
+    .autoindex .name { color: #888; font-size: 0.9em; margin-left: 0.5em; }
+</style>
+<h1>{{ .Title }}</h1>
+<ul class="autoindex">
+    {{- if .Parent }}
+    <li class="dir"><a href="../">../</a></li>
+    {{- end }}
+    {{- range .Entries }}
+    <li class="{{ if .IsDir }}dir{{ else }}file{{ end }}">
+        <a href="{{ .Href }}">{{ .Title }}</a>
+        {{- if ne .Title .Name }}<span class="name">{{ .Name }}</span>{{ end }}
+    </li>
+    {{- end }}
+</ul>`
+
+var autoIndexTmpl = template.Must(template.New("autoindex").Parse(autoIndexTemplate))
+
+// DirEntry describes a single item in a directory listing.
+type DirEntry struct {
+	Name  string
+	Title string
+	Href  string
+	IsDir bool
+}
+
+// renderDirectory renders a listing of the subdirectories and markdown files in dir.
+func (h *markdownHandler) renderDirectory(w http.ResponseWriter, r *http.Request, dir string) {
+	title, content, err := h.directoryContent(dir, r.URL.Path)
+	if err != nil {
+		h.serverError(w, r, "Unable to read directory")
+		log.Printf("Error listing directory %s: %v\n", dir, err)
+		return
+	}
+
+	h.renderPage(w, r, dir, PageData{Title: title, Content: content, dir: dir}, time.Time{})
+}
+
+// directoryContent builds the title and listing HTML for dir, which is served at urlPath.
+func (h *markdownHandler) directoryContent(dir, urlPath string) (string, template.HTML, error) {
+	entries, err := h.listDirectory(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	if h.rewriteLink != nil {
+		for i := range entries {
+			if !entries[i].IsDir {
+				entries[i].Href = h.rewriteLink(entries[i].Href)
+			}
+		}
+	}
+
+	title := "Index of " + urlPath
+	var buf bytes.Buffer
+	err = autoIndexTmpl.Execute(&buf, struct {
+		Title   string
+		Parent  bool
+		Entries []DirEntry
+	}{
+		Title:   title,
+		Parent:  urlPath != "/",
+		Entries: entries,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return title, template.HTML(buf.String()), nil
+}
+
+// listDirectory returns the visible subdirectories followed by the markdown files in dir.
+// Markdown files are titled by their first header, falling back to the file name.
+// Unpublished files, and entries that containedPath rejects, are left out.
+func (h *markdownHandler) listDirectory(dir string) ([]DirEntry, error) {
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs, files []DirEntry
+	for _, item := range items {
+		name := item.Name()
+		if isInternal(name) {
+			continue
+		}
+		if _, err := h.containedPath(filepath.Join(dir, name)); err != nil {
+			continue
+		}
+
+		if item.IsDir() {
+			dirs = append(dirs, DirEntry{Name: name + "/", Title: name + "/", Href: pathHref(name) + "/", IsDir: true})
+			continue
+		}
+
+		if !h.cfg.isMarkdown(name) {
+			continue
+		}
+
+		title := name
+		if fm, body, err := readMarkdown(filepath.Join(dir, name)); err == nil {
+			if !h.cfg.published(fm) {
+				continue
+			}
+			if t, ok := pageTitle(fm, body); ok {
+				title = t
+			}
+		}
+		files = append(files, DirEntry{Name: name, Title: title, Href: pathHref(name)})
+	}
+
+	return append(dirs, files...), nil
+}
+
+// pathHref escapes a file name for use as a relative link.
+func pathHref(name string) string {
+	return (&url.URL{Path: name}).String()
+}
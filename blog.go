@@ -0,0 +1,366 @@
+package mdssr
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// Template for the index of blog posts.
+const blogTemplate = `{{ .Intro }}
+<ul class="posts">
+    {{- range .Posts }}
+    <li>
+        <a href="{{ .URL }}">{{ .Title }}</a>
+        <time datetime="{{ .Date.Format "2006-01-02" }}">{{ .Date.Format "January 2, 2006" }}</time>
+        {{- with .Description }}
+        <p>{{ . }}</p>
+        {{- end }}
+    </li>
+    {{- end }}
+</ul>
+{{- if or .Newer .Older }}
+<nav class="pagination">
+    {{- with .Newer }}
+    <a href="{{ . }}" rel="prev">Newer posts</a>
+    {{- end }}
+    {{- with .Older }}
+    <a href="{{ . }}" rel="next">Older posts</a>
+    {{- end }}
+</nav>
+{{- end }}`
+
+var blogTmpl = template.Must(template.New("blog").Parse(blogTemplate))
+
+// postListing is the data of blogTemplate.
+type postListing struct {
+	Intro template.HTML
+	Posts []pageSummary
+	// Newer and Older link the neighbouring pages of the listing, if any.
+	Newer, Older string
+}
+
+// blogPost is a dated page in the blog directory.
+type blogPost struct {
+	rel       string
+	file      string
+	title     string
+	date      time.Time
+	permalink string
+}
+
+// blogIndex holds the posts of the blog.
+// It is built on first use and rebuilt lazily after the tree changes.
+type blogIndex struct {
+	mu    sync.Mutex
+	stale bool
+	posts *postSet
+}
+
+// postSet lists the posts of the blog, newest first. It isn't modified once built.
+type postSet struct {
+	posts []blogPost
+	// byPermalink and byRel map to indexes in posts.
+	byPermalink map[string]int
+	byRel       map[string]int
+}
+
+// invalidate marks the index for rebuilding on next use.
+func (b *blogIndex) invalidate() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stale = true
+}
+
+// blogPath returns the URL path of the blog index, relative to the mount point.
+func (h *markdownHandler) blogPath() string {
+	dir := strings.Trim(path.Clean("/"+h.cfg.Blog), "/")
+	if dir == "" {
+		return "/"
+	}
+	return "/" + dir + "/"
+}
+
+// blogPosts returns the posts of the blog, building the index if needed.
+func (h *markdownHandler) blogPosts() *postSet {
+	h.blog.mu.Lock()
+	defer h.blog.mu.Unlock()
+	if h.blog.stale || h.blog.posts == nil {
+		h.blog.posts = h.buildBlog()
+		h.blog.stale = false
+	}
+	return h.blog.posts
+}
+
+// buildBlog collects the pages with a date in the blog directory and gives each
+// a permalink of the form /blog/2024/05/slug/, where the slug is the slug from the
+// front matter or the file name.
+func (h *markdownHandler) buildBlog() *postSet {
+	b := &postSet{
+		byPermalink: make(map[string]int),
+		byRel:       make(map[string]int),
+	}
+
+	dirRel := strings.Trim(h.blogPath(), "/")
+	root := filepath.Join(h.basePath, filepath.FromSlash(dirRel))
+	err := h.walkMarkdown(root, func(file, rel string, _ fs.DirEntry) error {
+		name := h.cfg.pageName(path.Base(rel))
+		if h.isIndexFile(file) || h.cfg.isErrorPage(rel) {
+			return nil
+		}
+		fm, body, err := readMarkdown(file)
+		if err != nil {
+			log.Printf("Error reading post %s: %v\n", file, err)
+			return nil
+		}
+		if fm.Date.IsZero() || !h.cfg.published(fm) {
+			return nil
+		}
+
+		title, ok := pageTitle(fm, body)
+		if !ok {
+			title = name
+		}
+		slug, _ := fm.Params["slug"].(string)
+		if slug == "" {
+			slug = name
+		}
+		b.posts = append(b.posts, blogPost{
+			rel:       path.Join(dirRel, rel),
+			file:      file,
+			title:     title,
+			date:      fm.Date,
+			permalink: h.blogPath() + fm.Date.Format("2006/01/") + slugify(slug) + "/",
+		})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error indexing blog posts: %v\n", err)
+	}
+
+	sort.SliceStable(b.posts, func(i, j int) bool {
+		return b.posts[i].date.After(b.posts[j].date)
+	})
+	for i, post := range b.posts {
+		if other, ok := b.byPermalink[post.permalink]; ok {
+			log.Printf("Posts %s and %s share the permalink %s\n", b.posts[other].rel, post.rel, post.permalink)
+			continue
+		}
+		b.byPermalink[post.permalink] = i
+		b.byRel[post.rel] = i
+	}
+	return b
+}
+
+// postURL returns the permalink of the markdown file at rel, if it is a blog post.
+func (h *markdownHandler) postURL(rel string) (string, bool) {
+	if h.blog == nil {
+		return "", false
+	}
+	b := h.blogPosts()
+	i, ok := b.byRel[rel]
+	if !ok {
+		return "", false
+	}
+	return h.prefix + b.posts[i].permalink, true
+}
+
+// postDir returns the URL of the directory of the markdown file at path, if it is a blog post.
+func (h *markdownHandler) postDir(file string) (string, bool) {
+	if h.blog == nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(h.basePath, file)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if _, ok := h.postURL(rel); !ok {
+		return "", false
+	}
+	return h.prefix + "/" + path.Dir(rel) + "/", true
+}
+
+// canonicalURL returns the URL listings link the markdown file at rel with:
+// its permalink for blog posts, and its page URL for other pages.
+func (h *markdownHandler) canonicalURL(rel string) string {
+	if url, ok := h.postURL(rel); ok {
+		return url
+	}
+	return h.pageURL(rel)
+}
+
+// serveBlog serves the blog index pages and post permalinks.
+// It reports whether urlPath belonged to the blog.
+func (h *markdownHandler) serveBlog(w http.ResponseWriter, r *http.Request) bool {
+	urlPath := r.URL.Path
+	if urlPath != "/" && urlPath == strings.TrimSuffix(h.blogPath(), "/") {
+		h.redirect(w, r, h.blogPath())
+		return true
+	}
+	b := h.blogPosts()
+
+	if i, ok := b.byPermalink[urlPath]; ok {
+		post := b.posts[i]
+		// The access rules of the post's directory apply, not those of its permalink
+		if !h.allowed(r, h.pageURL(post.rel)) {
+			challenge(w)
+			return true
+		}
+		if info, err := os.Stat(post.file); err == nil {
+			h.renderMarkdown(w, r, post.file, info)
+			return true
+		}
+		return false
+	}
+
+	page := 1
+	if rest, ok := strings.CutPrefix(urlPath, h.blogPath()+"page/"); ok {
+		n, err := strconv.Atoi(strings.TrimSuffix(rest, "/"))
+		if err != nil || n < 2 || !strings.HasSuffix(rest, "/") {
+			return false
+		}
+		page = n
+	} else if urlPath != h.blogPath() {
+		return false
+	}
+
+	data, ok, err := h.blogPage(page, func(url string) bool { return h.allowed(r, url) })
+	if err != nil {
+		log.Printf("Error rendering blog index: %v\n", err)
+		h.serverError(w, r, "Error rendering blog index")
+		return true
+	}
+	if !ok {
+		return false
+	}
+	h.renderPage(w, r, urlPath, data, time.Time{})
+	return true
+}
+
+// blogPage returns the data of the n-th page of the blog index of the posts
+// whose page URL passes allowed, and whether there is one.
+// The blog directory's index.md, if any, introduces the first page.
+func (h *markdownHandler) blogPage(n int, allowed func(url string) bool) (PageData, bool, error) {
+	var posts []blogPost
+	for _, post := range h.blogPosts().posts {
+		if allowed(h.pageURL(post.rel)) {
+			posts = append(posts, post)
+		}
+	}
+	size := max(h.cfg.BlogPageSize, 1)
+	start := (n - 1) * size
+	if n > 1 && start >= len(posts) {
+		return PageData{}, false, nil
+	}
+	end := min(start+size, len(posts))
+
+	data := PageData{Title: h.cfg.SiteTitle}
+	if data.Title == "" {
+		data.Title = "Posts"
+	}
+	var intro template.HTML
+	if indexFile, info, ok := h.indexFile(filepath.Join(h.basePath, filepath.FromSlash(h.blogPath()))); ok {
+		page, err := h.loadPage(indexFile, info)
+		if err != nil {
+			return PageData{}, false, err
+		}
+		data = page.pageData()
+		if n == 1 {
+			intro = page.Content
+		}
+	}
+	if n > 1 {
+		data.Title = fmt.Sprintf("%s, page %d", data.Title, n)
+	}
+
+	listing := postListing{Intro: intro}
+	for _, post := range posts[start:end] {
+		info, err := os.Stat(post.file)
+		if err != nil {
+			continue
+		}
+		page, err := h.loadPage(post.file, info)
+		if err != nil {
+			return PageData{}, false, err
+		}
+		listing.Posts = append(listing.Posts, pageSummary{
+			URL:         h.prefix + post.permalink,
+			Title:       post.title,
+			Description: page.Meta.Description,
+			Date:        post.date,
+		})
+	}
+	if n == 2 {
+		listing.Newer = h.prefix + h.blogPath()
+	} else if n > 2 {
+		listing.Newer = h.prefix + h.blogPath() + "page/" + strconv.Itoa(n-1) + "/"
+	}
+	if end < len(posts) {
+		listing.Older = h.prefix + h.blogPath() + "page/" + strconv.Itoa(n+1) + "/"
+	}
+
+	var buf bytes.Buffer
+	if err := blogTmpl.Execute(&buf, listing); err != nil {
+		return PageData{}, false, err
+	}
+	data.Content = template.HTML(buf.String())
+	return data, true, nil
+}
+
+// pageDirKey stores the URL of the directory of the page being parsed, for
+// pages whose relative links must not depend on the URL they are served at.
+var pageDirKey = parser.NewContextKey()
+
+// postLinkTransformer makes relative link and image destinations root-relative,
+// so they keep working when a post is served at its permalink.
+type postLinkTransformer struct{}
+
+func (postLinkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	dir, ok := pc.Get(pageDirKey).(string)
+	if !ok {
+		return
+	}
+	base, err := url.Parse(dir)
+	if err != nil {
+		return
+	}
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = resolveRelative(base, n.Destination)
+		case *ast.Image:
+			n.Destination = resolveRelative(base, n.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// resolveRelative resolves dest against base if it is a relative path.
+// Fragments within the page are left alone.
+func resolveRelative(base *url.URL, dest []byte) []byte {
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return dest
+	}
+	return []byte(base.ResolveReference(u).String())
+}
@@ -0,0 +1,71 @@
+package mdssr
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlogAccess(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(root, "base")
+	writeFiles(t, root, map[string]string{
+		"outside.md": "---\ndate: 2024-05-03\ntags: [news]\n---\n# Outside\n",
+	})
+	writeFiles(t, base, map[string]string{
+		"blog/public.md":                 "---\ndate: 2024-05-01\ntags: [news]\n---\n# Public\n",
+		"blog/private/secret.md":         "---\ndate: 2024-05-02\ntags: [news]\n---\n# Secret\n",
+		"blog/private/" + accessFileName: "users: [alice]\n",
+	})
+	symlink(t, filepath.Join(root, "outside.md"), filepath.Join(base, "blog", "outside.md"))
+
+	cfg := DefaultConfig()
+	cfg.Blog = "blog"
+	cfg.Tags = true
+	cfg.BasicAuth = []string{"alice:secret", "bob:secret"}
+	h, err := newMarkdownHandler(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		user   string
+		status int
+		want   []string
+		absent []string
+	}{
+		{"/blog/2024/05/public/", "bob", http.StatusOK, []string{"Public"}, nil},
+		{"/blog/2024/05/secret/", "", http.StatusUnauthorized, nil, nil},
+		{"/blog/2024/05/secret/", "bob", http.StatusUnauthorized, nil, nil},
+		{"/blog/2024/05/secret/", "alice", http.StatusOK, []string{"Secret"}, nil},
+		{"/blog/2024/05/outside/", "alice", http.StatusNotFound, nil, nil},
+		{"/blog/", "bob", http.StatusOK, []string{"/blog/2024/05/public/"}, []string{"/blog/2024/05/secret/", "/blog/2024/05/outside/"}},
+		{"/blog/", "alice", http.StatusOK, []string{"/blog/2024/05/public/", "/blog/2024/05/secret/"}, []string{"/blog/2024/05/outside/"}},
+		{"/tags/news/", "bob", http.StatusOK, []string{"/blog/2024/05/public/"}, []string{"/blog/2024/05/secret/", "/blog/2024/05/outside/"}},
+		{"/tags/news/", "alice", http.StatusOK, []string{"/blog/2024/05/public/", "/blog/2024/05/secret/"}, []string{"/blog/2024/05/outside/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" as "+tt.user, func(t *testing.T) {
+			w := get(h, tt.path, tt.user)
+			body := w.Body.String()
+			if w.Code != tt.status {
+				t.Fatalf("GET %s as %q = %d, want %d", tt.path, tt.user, w.Code, tt.status)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("GET %s as %q doesn't contain %s:\n%s", tt.path, tt.user, want, body)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(body, absent) {
+					t.Errorf("GET %s as %q contains %s:\n%s", tt.path, tt.user, absent, body)
+				}
+			}
+		})
+	}
+}
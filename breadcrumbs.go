@@ -0,0 +1,58 @@
+package mdssr
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Breadcrumb is a directory enclosing a page.
+type Breadcrumb struct {
+	Title string
+	// URL is empty for directories that have no page of their own.
+	URL string
+}
+
+// breadcrumbs returns the directories enclosing the page at url, a path including
+// the mount point, from the root down. Each is titled by its index page, falling
+// back to its name. Directories that don't exist in the tree, like the date
+// parts of blog permalinks, are left out.
+func (h *markdownHandler) breadcrumbs(url string) []Breadcrumb {
+	urlPath := strings.TrimPrefix(url, h.prefix)
+	if urlPath == "" || urlPath == "/" {
+		return nil
+	}
+
+	dirs := []string{"/"}
+	if parent := strings.Trim(path.Dir(strings.TrimSuffix(urlPath, "/")), "/"); parent != "" {
+		for _, segment := range strings.Split(parent, "/") {
+			dirs = append(dirs, path.Join(dirs[len(dirs)-1], segment))
+		}
+	}
+
+	var crumbs []Breadcrumb
+	for _, dir := range dirs {
+		abs := filepath.Join(h.basePath, filepath.FromSlash(dir))
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			continue
+		}
+
+		crumb := Breadcrumb{Title: path.Base(dir)}
+		if dir == "/" {
+			crumb.Title = "Home"
+		}
+		index, _, hasIndex := h.indexFile(abs)
+		if fm, body, err := readMarkdown(index); hasIndex && err == nil {
+			if title, ok := pageTitle(fm, body); ok {
+				crumb.Title = title
+			}
+		}
+		// Directories render their index page
+		if hasIndex || h.cfg.AutoIndex {
+			crumb.URL = h.prefix + strings.TrimSuffix(dir, "/") + "/"
+		}
+		crumbs = append(crumbs, crumb)
+	}
+	return crumbs
+}
@@ -0,0 +1,325 @@
+package mdssr
+
+import (
+	"flag"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// runBuild implements the build subcommand, which exports the markdown tree as a static site.
+func runBuild(args []string) {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	loadConfig := configFlags(flags, bindFlags, bindBuildFlags)
+
+	// Parse the flags
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		log.Fatalln("Usage: markdown_renderer build [options] <base_path or .zip/.tar.gz archive>")
+	}
+
+	absBasePath, cleanup, err := openSource(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Error opening base path: %v\n", err)
+	}
+	defer cleanup()
+	fatalf := fatalfFor(cleanup)
+
+	cfg, err := loadConfig(absBasePath)
+	if err != nil {
+		fatalf("Error loading config: %v\n", err)
+	}
+
+	absOutDir, err := filepath.Abs(cfg.Output)
+	if err != nil {
+		fatalf("Error getting absolute output path: %v\n", err)
+	}
+
+	pages, err := buildSite(absBasePath, absOutDir, cfg)
+	if err != nil {
+		fatalf("Error building site: %v\n", err)
+	}
+	log.Printf("Built %d pages into %s\n", pages, absOutDir)
+}
+
+// buildSite renders every markdown file under basePath to an .html file in outDir
+// and copies all other files as they are. Intra-site .md links are rewritten to .html,
+// or to extension-less links when clean URLs are enabled.
+// It returns the number of pages rendered.
+func buildSite(basePath, outDir string, cfg Config) (int, error) {
+	// Every file is rendered exactly once, and there is no server to search, resize images or save edits
+	cfg.Cache = false
+	cfg.Search = false
+	cfg.ImageResize = false
+	cfg.Editable = false
+
+	h, err := newMarkdownHandler(basePath, cfg)
+	if err != nil {
+		return 0, err
+	}
+	// Clean links are served by static hosts that resolve /page to page.html
+	if !cfg.CleanURLs {
+		h.rewriteLink = htmlLink
+		h.md = h.markdownFor(cfg)
+	}
+
+	if err := writeAssets(filepath.Join(outDir, strings.Trim(assetsPrefix, "/"))); err != nil {
+		return 0, err
+	}
+
+	pages := 0
+	err = filepath.WalkDir(basePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip hidden files, the config and access files, order files and the output directory itself
+		if path != basePath && cfg.isHidden(d.Name()) || path == outDir || path == filepath.Join(basePath, configFileName) || d.Name() == accessFileName || d.Name() == orderFileName || isOverrideFile(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Like the server, leave out symlinks resolving outside the tree
+		if _, err := h.containedPath(path); err != nil {
+			log.Printf("Skipping %s: %v\n", path, err)
+			return nil
+		}
+
+		rel, err := filepath.Rel(basePath, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(outDir, rel)
+
+		if d.IsDir() {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			if _, _, hasIndex := h.indexFile(path); !cfg.AutoIndex || hasIndex {
+				return nil
+			}
+
+			urlPath := "/"
+			if rel != "." {
+				urlPath += filepath.ToSlash(rel) + "/"
+			}
+			title, content, err := h.directoryContent(path, urlPath)
+			if err != nil {
+				return err
+			}
+			data := PageData{URL: h.prefix + urlPath, Title: title, Content: content, dir: path}
+			return h.writePage(filepath.Join(target, "index.html"), data)
+		}
+
+		if cfg.isMarkdown(d.Name()) {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			page, err := h.loadPage(path, info)
+			if err != nil {
+				return err
+			}
+			if !cfg.published(page.Meta) {
+				return nil
+			}
+			pages++
+			data := page.pageData()
+			data.URL = h.pageURL(filepath.ToSlash(rel))
+			if err := h.writePage(htmlLink(target), data); err != nil {
+				return err
+			}
+
+			// Static hosts only serve index.html for directories, so index pages like README.md get a copy
+			indexHTML := filepath.Join(filepath.Dir(target), "index.html")
+			if htmlLink(target) == indexHTML || !h.isIndexFile(path) || fileExists(filepath.Join(filepath.Dir(path), "index.html")) {
+				return nil
+			}
+			data.URL = h.prefix + "/"
+			if dir := filepath.ToSlash(filepath.Dir(rel)); dir != "." {
+				data.URL += dir + "/"
+			}
+			return h.writePage(indexHTML, data)
+		}
+
+		// The walk doesn't follow symlinks to directories
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			log.Printf("Skipping %s: symlinked directories aren't built\n", path)
+			return nil
+		}
+		return copyFile(path, target)
+	})
+	if err != nil {
+		return pages, err
+	}
+
+	if h.blog != nil {
+		if err := h.writeBlog(outDir); err != nil {
+			return pages, err
+		}
+	}
+	if h.tags != nil {
+		if err := h.writeTaxonomies(outDir); err != nil {
+			return pages, err
+		}
+	}
+	if h.redirects != nil {
+		if err := h.writeRedirects(outDir); err != nil {
+			return pages, err
+		}
+	}
+	if cfg.Sitemap {
+		if err := h.writeSitemap(outDir); err != nil {
+			return pages, err
+		}
+	}
+	if cfg.Feed {
+		if err := h.writeFeed(outDir); err != nil {
+			return pages, err
+		}
+	}
+	return pages, nil
+}
+
+// writeBlog writes the pages of the blog index and a copy of each post at its
+// permalink to outDir. The first index page replaces the blog directory's index.html.
+func (h *markdownHandler) writeBlog(outDir string) error {
+	for n := 1; ; n++ {
+		data, ok, err := h.blogPage(n, func(string) bool { return true })
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		urlPath := h.blogPath()
+		if n > 1 {
+			urlPath += "page/" + strconv.Itoa(n) + "/"
+		}
+		data.URL = h.prefix + urlPath
+		if err := h.writeIndexPage(outDir, urlPath, data); err != nil {
+			return err
+		}
+	}
+
+	for _, post := range h.blogPosts().posts {
+		info, err := os.Stat(post.file)
+		if err != nil {
+			return err
+		}
+		page, err := h.loadPage(post.file, info)
+		if err != nil {
+			return err
+		}
+		data := page.pageData()
+		data.URL = h.prefix + post.permalink
+		if err := h.writeIndexPage(outDir, post.permalink, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTaxonomies writes the index of each taxonomy and the listing of each of its terms to outDir.
+func (h *markdownHandler) writeTaxonomies(outDir string) error {
+	all := func(string) bool { return true }
+	for i, tax := range taxonomies {
+		for _, slug := range append([]string{""}, slices.Collect(maps.Keys(h.taxonomyTerms()[i]))...) {
+			data, _, err := h.taxonomyPage(i, slug, all)
+			if err != nil {
+				return err
+			}
+			urlPath := tax.path
+			if slug != "" {
+				urlPath += slug + "/"
+			}
+			data.URL = h.prefix + urlPath
+			if err := h.writeIndexPage(outDir, urlPath, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeIndexPage writes data as the index.html of the directory urlPath in outDir.
+func (h *markdownHandler) writeIndexPage(outDir, urlPath string, data PageData) error {
+	dir := filepath.Join(outDir, filepath.FromSlash(urlPath))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return h.writePage(filepath.Join(dir, "index.html"), data)
+}
+
+// writeFeed writes the Atom feed to outDir. Feeds need absolute URLs, so this
+// requires the site URL.
+func (h *markdownHandler) writeFeed(outDir string) error {
+	origin := h.siteOrigin(nil)
+	if origin == "" {
+		log.Println("Skipping the feed, which needs --site-url")
+		return nil
+	}
+	data, err := h.feed(origin, func(string) bool { return true })
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outDir, strings.TrimPrefix(feedPath, "/")), data, 0o644)
+}
+
+// writeSitemap writes the sitemap and, unless the tree has one, robots.txt to outDir.
+// Sitemaps need absolute URLs, so this requires the site URL.
+func (h *markdownHandler) writeSitemap(outDir string) error {
+	origin := h.siteOrigin(nil)
+	if origin == "" {
+		log.Println("Skipping the sitemap, which needs --site-url")
+		return nil
+	}
+	data, err := h.sitemap(origin, func(string) bool { return true })
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(outDir, strings.TrimPrefix(sitemapPath, "/")), data, 0o644); err != nil {
+		return err
+	}
+	robots := filepath.Join(outDir, strings.TrimPrefix(robotsPath, "/"))
+	if fileExists(filepath.Join(h.basePath, "robots.txt")) {
+		return nil
+	}
+	return os.WriteFile(robots, h.robots(origin), 0o644)
+}
+
+// writePage executes the page template with data and writes the result to path.
+func (h *markdownHandler) writePage(path string, data PageData) error {
+	page, err := h.executePage(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, page, 0o644)
+}
+
+// copyFile copies the contents of src to dst, creating or truncating dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
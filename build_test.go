@@ -0,0 +1,89 @@
+package mdssr
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildSiteTraversal(t *testing.T) {
+	base := traversalTree(t)
+	tests := []struct {
+		file   string
+		exists bool
+		follow bool
+	}{
+		{"index.html", true, true},
+		{"in.txt", true, true},
+		{"link-in.txt", true, true},
+		{"sub/page.html", true, true},
+		{".well-known/security.txt", true, true},
+		{"link-out.txt", false, true},
+		{"sub/link-out.html", false, true},
+		{"dir-out/secret.txt", false, false},
+		{".secret", false, false},
+		{"_draft.html", false, false},
+		{"sub/.access", false, false},
+		{configFileName, false, false},
+	}
+	for _, follow := range []bool{false, true} {
+		out := t.TempDir()
+		cfg := DefaultConfig()
+		cfg.FollowSymlinks = follow
+		if _, err := buildSite(base, out, cfg); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			want := tt.exists || follow && tt.follow
+			t.Run(tt.file, func(t *testing.T) {
+				_, err := os.Lstat(filepath.Join(out, filepath.FromSlash(tt.file)))
+				if exists := err == nil; exists != want {
+					t.Errorf("%s built with follow-symlinks %v exists = %v, want %v", tt.file, follow, exists, want)
+				}
+			})
+		}
+	}
+}
+
+func TestIndexTraversal(t *testing.T) {
+	base := traversalTree(t)
+	writeFiles(t, filepath.Dir(base), map[string]string{"outside.md": "---\ntags: [leak]\n---\n# Leaked\n"})
+	paths := []string{"/sub/", searchPath + "?q=leaked", sitemapPath, feedPath, "/tags/"}
+	for _, follow := range []bool{false, true} {
+		cfg := DefaultConfig()
+		cfg.FollowSymlinks = follow
+		cfg.AutoIndex, cfg.Nav, cfg.Search, cfg.Sitemap, cfg.Feed, cfg.Tags = true, true, true, true, true, true
+		h, err := newMarkdownHandler(base, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The default template doesn't show the navigation
+		var titles []string
+		var collect func(items []NavItem)
+		collect = func(items []NavItem) {
+			for _, item := range items {
+				titles = append(titles, item.Title)
+				collect(item.Children)
+			}
+		}
+		collect(h.navFor("/"))
+		if leaked := slices.Contains(titles, "Leaked"); leaked != follow {
+			t.Errorf("navigation with follow-symlinks %v shows the page outside the tree = %v: %v", follow, leaked, titles)
+		}
+		for _, path := range paths {
+			t.Run(path, func(t *testing.T) {
+				w := get(h, path, "")
+				if w.Code != http.StatusOK {
+					t.Fatalf("GET %s = %d", path, w.Code)
+				}
+				body := w.Body.String()
+				if leaked := strings.Contains(body, "link-out") || strings.Contains(body, "Leaked") || strings.Contains(body, "/tags/leak/"); leaked != follow {
+					t.Errorf("GET %s with follow-symlinks %v shows the page outside the tree = %v:\n%s", path, follow, leaked, body)
+				}
+			})
+		}
+	}
+}
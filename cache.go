@@ -0,0 +1,156 @@
+package mdssr
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// renderedPage is the result of converting a markdown file to HTML.
+type renderedPage struct {
+	Title   string
+	Meta    FrontMatter
+	Params  map[string]any
+	Content template.HTML
+	TOC     template.HTML
+	Head    template.HTML
+	Assets  pageAssets
+	ModTime time.Time
+	Size    int64
+	// Includes are the files inlined into the page.
+	Includes []includedFile
+
+	// file is the markdown file, dir its directory, and version the version of
+	// the directory's layout when the page was rendered.
+	file    string
+	dir     string
+	version uint64
+}
+
+// pageData returns the template data for the page.
+func (p *renderedPage) pageData() PageData {
+	return PageData{
+		Title:       p.Title,
+		Description: p.Meta.Description,
+		Image:       p.Meta.Image,
+		Author:      p.Meta.Author,
+		Date:        p.Meta.Date,
+		Params:      p.Params,
+		CSS:         p.Assets.CSS,
+		JS:          p.Assets.JS,
+		Content:     p.Content,
+		TOC:         p.TOC,
+		Head:        p.Head,
+		dir:         p.dir,
+		file:        p.file,
+	}
+}
+
+// renderCache stores rendered pages keyed by file path.
+// An entry is only valid while the modification time and size of the file and
+// the files it includes are unchanged.
+type renderCache struct {
+	mu      sync.RWMutex
+	entries map[string]*renderedPage
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{entries: make(map[string]*renderedPage)}
+}
+
+// get returns the cached page for path if it is still fresh for info.
+func (c *renderCache) get(path string, info os.FileInfo) (*renderedPage, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	page, ok := c.entries[path]
+	if !ok || !page.ModTime.Equal(info.ModTime()) || page.Size != info.Size() {
+		return nil, false
+	}
+	for _, f := range page.Includes {
+		if f.changed() {
+			return nil, false
+		}
+	}
+	return page, true
+}
+
+// put stores page as the rendered output for path.
+func (c *renderCache) put(path string, page *renderedPage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[path] = page
+}
+
+// reset drops every cached page.
+func (c *renderCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// remove drops the pages of the files that match, returning how many there were.
+func (c *renderCache) remove(match func(file string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	maps.DeleteFunc(c.entries, func(file string, _ *renderedPage) bool { return match(file) })
+	return n - len(c.entries)
+}
+
+// etag builds a weak validator from the modification time and size of the file at
+// path and the version of its layout, so pages change when the template or options do.
+// With wiki links, it also changes when pages are added or removed, and with
+// includes when any of the included files change. Pages showing indexes of the
+// tree, like the navigation, change whenever the tree does, and every page with
+// the host and query of r, which the template may show. With access control,
+// pages also differ by user, as the indexes only list the pages users may read.
+func (h *markdownHandler) etag(r *http.Request, path string, info os.FileInfo, includes ...includedFile) string {
+	version := h.layoutFor(filepath.Dir(path)).version
+	if h.wiki != nil {
+		_, pages := h.wikiPages()
+		version ^= pages
+	}
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s\x00%s\x00", r.Host, r.URL.RawQuery)
+	if h.auth != nil {
+		// The credentials were checked before the page was rendered
+		user, _, _ := r.BasicAuth()
+		fmt.Fprintf(hash, "%s\x00", user)
+	}
+	if h.showsTree() {
+		fmt.Fprintf(hash, "%d\x00", h.treeModTime.Load())
+	}
+	for _, f := range includes {
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00", f.path, f.modTime.UnixNano(), f.size)
+	}
+	version ^= hash.Sum64()
+	return fmt.Sprintf(`W/"%x-%x-%x"`, info.ModTime().UnixNano(), info.Size(), version)
+}
+
+// lastModified returns when the page of the file described by info last
+// changed, which for pages showing indexes of the tree is when the tree did.
+func (h *markdownHandler) lastModified(info os.FileInfo) time.Time {
+	if treeModTime := time.Unix(0, h.treeModTime.Load()); h.showsTree() && treeModTime.After(info.ModTime()) {
+		return treeModTime
+	}
+	return info.ModTime()
+}
+
+// showsTree reports whether pages show indexes of the tree, such as the
+// navigation or the recent pages, which change along with it.
+func (h *markdownHandler) showsTree() bool {
+	return h.nav != nil || h.tags != nil || h.recent != nil
+}
+
+// handlerVersion hashes the template and options that shape rendered pages.
+func handlerVersion(tmplText string, cfg Config) uint64 {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s\x00%+v", tmplText, cfg)
+	return hash.Sum64()
+}
@@ -0,0 +1,88 @@
+package mdssr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// conditionalGet serves a GET request for target from h as user, with an
+// If-None-Match header if etag isn't empty.
+func conditionalGet(h http.Handler, target, user, etag string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if user != "" {
+		r.SetBasicAuth(user, "secret")
+	}
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestConditionalGet(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"page.md": "# Page\n"})
+	h, err := newMarkdownHandler(base, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := conditionalGet(h, "/page.md", "", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Cache-Control") != "" {
+		t.Fatalf("GET /page.md = %d with ETag %q and Cache-Control %q", w.Code, etag, w.Header().Get("Cache-Control"))
+	}
+	if w := conditionalGet(h, "/page.md", "", etag); w.Code != http.StatusNotModified {
+		t.Errorf("GET /page.md with its ETag = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	// Saving the page changes its modification time, whatever the clock resolution
+	file := filepath.Join(base, "page.md")
+	writeFiles(t, base, map[string]string{"page.md": "# Changed\n"})
+	if err := os.Chtimes(file, time.Time{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	w = conditionalGet(h, "/page.md", "", etag)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<title>Changed</title>") {
+		t.Errorf("GET /page.md with its old ETag after a change = %d:\n%s", w.Code, w.Body)
+	}
+}
+
+func TestConditionalGetPerUser(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"page.md":                   "# Page\n",
+		"private/index.md":          "# Private\n",
+		"private/" + accessFileName: "users: [alice]\n",
+	})
+	cfg := DefaultConfig()
+	cfg.Nav = true
+	cfg.BasicAuth = []string{"alice:secret", "bob:secret"}
+	h, err := newMarkdownHandler(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := conditionalGet(h, "/page.md", "alice", "")
+	etag := alice.Header().Get("ETag")
+	if alice.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET /page.md as alice = %d with ETag %q", alice.Code, etag)
+	}
+	if cc := alice.Header().Get("Cache-Control"); cc != "private" {
+		t.Errorf("GET /page.md with access control sent Cache-Control %q, want private", cc)
+	}
+	if w := conditionalGet(h, "/page.md", "alice", etag); w.Code != http.StatusNotModified {
+		t.Errorf("GET /page.md as alice with her ETag = %d, want %d", w.Code, http.StatusNotModified)
+	}
+	// Bob's navigation leaves out the private pages, so he must not get alice's page
+	bob := conditionalGet(h, "/page.md", "bob", etag)
+	if bob.Code != http.StatusOK || bob.Header().Get("ETag") == etag {
+		t.Errorf("GET /page.md as bob with alice's ETag = %d with ETag %q", bob.Code, bob.Header().Get("ETag"))
+	}
+}
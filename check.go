@@ -0,0 +1,203 @@
+package mdssr
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// linkPattern matches the links and image sources in rendered pages.
+var linkPattern = regexp.MustCompile(`\b(href|src)="([^"]*)"`)
+
+// maxCheckRedirects limits how many redirects a checked link may go through.
+const maxCheckRedirects = 10
+
+// runCheck implements the check subcommand, which reports broken links, missing
+// images and orphaned pages in the markdown tree. It exits with status 1 if any
+// link or image is broken, or with --strict, if any page is orphaned.
+func runCheck(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	loadConfig := configFlags(flags, bindFlags, bindCheckFlags)
+
+	// Parse the flags
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		log.Fatalln("Usage: markdown_renderer check [options] <base_path or .zip/.tar.gz archive>")
+	}
+
+	absBasePath, cleanup, err := openSource(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Error opening base path: %v\n", err)
+	}
+	defer cleanup()
+	fatalf := fatalfFor(cleanup)
+
+	cfg, err := loadConfig(absBasePath)
+	if err != nil {
+		fatalf("Error loading config: %v\n", err)
+	}
+
+	report, err := checkSite(absBasePath, cfg)
+	if err != nil {
+		fatalf("Error checking site: %v\n", err)
+	}
+	for _, problem := range report.problems {
+		fmt.Println(problem)
+	}
+	log.Printf("Checked %d pages: %d broken links, %d missing images, %d orphaned pages\n", report.pages, report.brokenLinks, report.missingImages, report.orphans)
+	if report.brokenLinks > 0 || report.missingImages > 0 || cfg.Strict && report.orphans > 0 {
+		cleanup()
+		os.Exit(1)
+	}
+}
+
+// checkReport is what checkSite found, with a line per problem.
+type checkReport struct {
+	pages                               int
+	brokenLinks, missingImages, orphans int
+	problems                            []string
+}
+
+// checkSite renders every published page under basePath and requests each of
+// the links and images in it from the handler serving basePath, so they resolve
+// exactly as they do for visitors. Links to other sites and mounts are not
+// checked. Pages no other page links to, apart from the home page, are orphaned.
+func checkSite(basePath string, cfg Config) (*checkReport, error) {
+	cfg.Search = false
+	h, err := newMarkdownHandler(basePath, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &checkReport{}
+	statuses := make(map[string]int)
+	linked := make(map[string]bool)
+	var pages []string
+	err = h.walkMarkdown(basePath, func(file, rel string, _ fs.DirEntry) error {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		page, err := h.loadPage(file, info)
+		if err != nil {
+			return fmt.Errorf("rendering %s: %w", rel, err)
+		}
+		if !cfg.published(page.Meta) {
+			return nil
+		}
+		report.pages++
+		pages = append(pages, rel)
+
+		pageURL := strings.TrimPrefix(h.pageURL(rel), h.prefix)
+		for _, m := range linkPattern.FindAllStringSubmatch(string(page.Content), -1) {
+			attr, link := m[1], html.UnescapeString(m[2])
+			target, ok := h.checkTarget(pageURL, link)
+			if !ok {
+				continue
+			}
+			if key := h.pageKey(target.Path); key != h.pageKey(pageURL) {
+				linked[key] = true
+			}
+
+			status, ok := statuses[target.String()]
+			if !ok {
+				status = h.probe(target)
+				statuses[target.String()] = status
+			}
+			if status < http.StatusBadRequest || status == http.StatusUnauthorized {
+				continue
+			}
+			if attr == "src" {
+				report.missingImages++
+				report.problems = append(report.problems, fmt.Sprintf("%s: missing image %s (%d %s)", rel, link, status, http.StatusText(status)))
+			} else {
+				report.brokenLinks++
+				report.problems = append(report.problems, fmt.Sprintf("%s: broken link %s (%d %s)", rel, link, status, http.StatusText(status)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rel := range pages {
+		if key := h.pageKey("/" + rel); key != "" && !linked[key] {
+			report.orphans++
+			report.problems = append(report.problems, fmt.Sprintf("%s: orphaned page, no other page links to it", rel))
+		}
+	}
+	return report, nil
+}
+
+// checkTarget resolves link, found in the page at pageURL, to the URL to request
+// from the handler, without the base URL. It reports false for links that aren't
+// served by the handler, like those to other sites, other mounts or within the page.
+func (h *markdownHandler) checkTarget(pageURL, link string) (*url.URL, bool) {
+	ref, err := url.Parse(link)
+	if err != nil || ref.Scheme != "" || ref.Host != "" || ref.Path == "" {
+		return nil, false
+	}
+	base := &url.URL{Path: pageURL}
+	if strings.HasPrefix(ref.Path, "/") {
+		if ref.Path != h.prefix && !strings.HasPrefix(ref.Path, h.prefix+"/") {
+			return nil, false
+		}
+		ref.Path = strings.TrimPrefix(ref.Path, h.prefix)
+		if ref.Path == "" {
+			ref.Path = "/"
+		}
+	}
+	target := base.ResolveReference(ref)
+	target.Fragment = ""
+	for _, m := range h.cfg.Mounts {
+		if m.Host == "" && (target.Path == m.prefix() || strings.HasPrefix(target.Path, m.prefix()+"/")) {
+			return nil, false
+		}
+	}
+	return target, true
+}
+
+// probe requests target from the handler and returns the status it ends up
+// with, following redirects.
+func (h *markdownHandler) probe(target *url.URL) int {
+	for range maxCheckRedirects {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target.RequestURI(), nil))
+		location := rec.Header().Get("Location")
+		if rec.Code < 300 || rec.Code >= 400 || location == "" {
+			return rec.Code
+		}
+		next, err := url.Parse(location)
+		if err != nil || next.Host != "" {
+			return rec.Code
+		}
+		target = target.ResolveReference(next)
+	}
+	return http.StatusLoopDetected
+}
+
+// pageKey returns the page that urlPath, a path without the base URL, refers to, leaving out
+// the markdown or .html extension and index file names, so the links to a page
+// in any of the forms it's served under have the same key. The home page is "".
+func (h *markdownHandler) pageKey(urlPath string) string {
+	urlPath = path.Clean("/" + urlPath)
+	for _, ext := range append(h.cfg.pageExts(), ".html") {
+		urlPath = strings.TrimSuffix(urlPath, ext)
+	}
+	if slices.Contains(h.cfg.IndexFiles, path.Base(urlPath)) {
+		urlPath = path.Dir(urlPath)
+	}
+	return strings.TrimSuffix(urlPath, "/")
+}
@@ -0,0 +1,8 @@
+// Command mdssr serves a tree of markdown files as HTML pages.
+package main
+
+import "mdssr"
+
+func main() {
+	mdssr.Main()
+}
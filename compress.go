@@ -0,0 +1,198 @@
+package mdssr
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// minCompressSize is the smallest response worth compressing.
+const minCompressSize = 1024
+
+// compressHandler compresses the responses of next with brotli or gzip,
+// whichever the client prefers, when they are large enough and of a textual type.
+func compressHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding returns the supported encoding the client prefers, or "" if
+// it accepts neither. Brotli wins ties since it compresses text better.
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "br" && name != "gzip" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ || (q == bestQ && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether responses of the given content type benefit from compression.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "text/event-stream":
+		// Events must reach the client as soon as they are written
+		return false
+	case "application/json", "application/javascript", "application/xml",
+		"application/rss+xml", "application/atom+xml", "image/svg+xml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+// compressWriter holds back the start of a response until it knows whether the
+// response is worth compressing, then either compresses or passes it through.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+
+	h := cw.Header()
+	// Leave alone responses without a body, already encoded ones and partial
+	// content, whose byte ranges refer to the uncompressed representation
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		cw.passThrough()
+		return
+	}
+	if ct := h.Get("Content-Type"); ct != "" && !compressible(ct) {
+		cw.passThrough()
+		return
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressSize {
+		cw.passThrough()
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= minCompressSize {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+		if compressible(cw.Header().Get("Content-Type")) {
+			cw.startCompression()
+		} else {
+			cw.passThrough()
+		}
+	}
+	return len(p), nil
+}
+
+// passThrough sends the response uncompressed.
+func (cw *compressWriter) passThrough() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.flushBuffer(cw.ResponseWriter)
+}
+
+// startCompression sends the response compressed with the negotiated encoding.
+func (cw *compressWriter) startCompression() {
+	cw.decided = true
+	h := cw.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", cw.encoding)
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if cw.encoding == "br" {
+		cw.enc = brotli.NewWriter(cw.ResponseWriter)
+	} else {
+		cw.enc = gzip.NewWriter(cw.ResponseWriter)
+	}
+	cw.flushBuffer(cw.enc)
+}
+
+func (cw *compressWriter) flushBuffer(w io.Writer) {
+	if len(cw.buf) > 0 {
+		_, _ = w.Write(cw.buf)
+	}
+	cw.buf = nil
+}
+
+// Flush sends what has been written so far, uncompressed if it is still too small to tell.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.passThrough()
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			// Nothing was written, let net/http send its default response
+			return nil
+		}
+		cw.passThrough()
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
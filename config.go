@@ -0,0 +1,401 @@
+package mdssr
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the config file looked up in the base path when --config is not given.
+const configFileName = "mdssr.yaml"
+
+// Config holds the options that control how the markdown tree is served.
+// Every option can be set in the config file under its flag name and on the
+// command line, which takes precedence.
+type Config struct {
+	MarkdownExts   []string `yaml:"md-exts"`
+	IndexFiles     []string `yaml:"index-files"`
+	CSS            []string `yaml:"css"`
+	JS             []string `yaml:"js"`
+	Template       string   `yaml:"template"`
+	TemplateDir    string   `yaml:"template-dir"`
+	Theme          string   `yaml:"theme"`
+	ColorScheme    string   `yaml:"default-color-scheme"`
+	Languages      []string `yaml:"languages"`
+	AutoIndex      bool     `yaml:"autoindex"`
+	Cache          bool     `yaml:"cache"`
+	CleanURLs      bool     `yaml:"clean-urls"`
+	Mermaid        bool     `yaml:"mermaid"`
+	MermaidJS      string   `yaml:"mermaid-js"`
+	Math           bool     `yaml:"math"`
+	Admonitions    bool     `yaml:"admonitions"`
+	Emoji          bool     `yaml:"emoji"`
+	HeadingAnchors bool     `yaml:"heading-anchors"`
+	Extensions     []string `yaml:"extensions"`
+	Search         bool     `yaml:"search"`
+	Nav            bool     `yaml:"nav"`
+	PrevNext       bool     `yaml:"prev-next"`
+	Raw            bool     `yaml:"raw"`
+	JSON           bool     `yaml:"json"`
+	PDFCommand     string   `yaml:"pdf-command"`
+	HTML           bool     `yaml:"html"`
+	Sanitize       bool     `yaml:"sanitize"`
+	Include        bool     `yaml:"include"`
+	Shortcodes     bool     `yaml:"shortcodes"`
+	Images         bool     `yaml:"images"`
+	WikiLinks      bool     `yaml:"wiki-links"`
+	WikiCreate     string   `yaml:"wiki-create"`
+	SiteURL        string   `yaml:"site-url"`
+	SiteTitle      string   `yaml:"site-title"`
+	BaseURL        string   `yaml:"base-url"`
+	EditBaseURL    string   `yaml:"edit-base-url"`
+	Feed           bool     `yaml:"feed"`
+	Blog           string   `yaml:"blog"`
+	BlogPageSize   int      `yaml:"blog-page-size"`
+	Tags           bool     `yaml:"tags"`
+	Recent         int      `yaml:"recent"`
+	Redirects      bool     `yaml:"redirects"`
+	Sitemap        bool     `yaml:"sitemap"`
+	Drafts         bool     `yaml:"drafts"`
+	Hidden         bool     `yaml:"hidden"`
+	FollowSymlinks bool     `yaml:"follow-symlinks"`
+	RobotsDisallow []string `yaml:"robots-disallow"`
+	MaxPageSize    int64    `yaml:"max-page-size"`
+	MaxStreams     int      `yaml:"max-streams"`
+
+	// Renderer options
+	Renderers       map[string]string `yaml:"renderers"`
+	AsciidocCommand string            `yaml:"asciidoc-command"`
+	RSTCommand      string            `yaml:"rst-command"`
+
+	// Server options
+	Mounts     []Mount `yaml:"mounts"`
+	Listen     string  `yaml:"listen"`
+	Watch      bool    `yaml:"watch"`
+	Prerender  bool    `yaml:"prerender"`
+	Compress   bool    `yaml:"compress"`
+	AccessLog  string  `yaml:"access-log"`
+	Metrics    bool    `yaml:"metrics"`
+	Health     bool    `yaml:"health"`
+	RateLimit  float64 `yaml:"rate-limit"`
+	RateBurst  int     `yaml:"rate-burst"`
+	TrustProxy bool    `yaml:"trust-proxy"`
+	MaxURL     int     `yaml:"max-url"`
+	MaxBody    int64   `yaml:"max-body"`
+	// AdminListen, if set, serves the monitoring and admin endpoints on their own address.
+	AdminListen string `yaml:"admin-listen"`
+	FastCGI     string `yaml:"fcgi"`
+	// AdminToken, if set, enables the admin endpoints for clients sending it.
+	AdminToken string `yaml:"admin-token"`
+	// ImageResize serves scaled down images, kept in ImageCache.
+	ImageResize bool   `yaml:"image-resize"`
+	ImageCache  string `yaml:"image-cache"`
+	// Editable serves the editor of the pages, committing saves with EditCommit.
+	Editable   bool `yaml:"editable"`
+	EditCommit bool `yaml:"edit-commit"`
+
+	// Git options
+	GitURL           string        `yaml:"git-url"`
+	GitRef           string        `yaml:"git-ref"`
+	GitPull          time.Duration `yaml:"git-pull"`
+	GitWebhookSecret string        `yaml:"git-webhook-secret"`
+
+	// TLS options
+	TLSCert       string   `yaml:"tls-cert"`
+	TLSKey        string   `yaml:"tls-key"`
+	Autocert      []string `yaml:"autocert"`
+	AutocertCache string   `yaml:"autocert-cache"`
+
+	// Access control options
+	BasicAuth []string              `yaml:"basic-auth"`
+	Htpasswd  string                `yaml:"htpasswd"`
+	Access    map[string]AccessRule `yaml:"access"`
+
+	// Security header options
+	SecurityHeaders bool   `yaml:"security-headers"`
+	CSP             string `yaml:"csp"`
+	ReferrerPolicy  string `yaml:"referrer-policy"`
+	FrameOptions    string `yaml:"frame-options"`
+
+	// Build options
+	Output string `yaml:"output"`
+
+	// Check options
+	Strict bool `yaml:"strict"`
+}
+
+// colorSchemes are the values of --default-color-scheme.
+var colorSchemes = []string{"auto", "light", "dark"}
+
+// DefaultConfig returns the options used when neither the config file nor a flag sets them.
+func DefaultConfig() Config {
+	return Config{
+		MarkdownExts:   []string{".md"},
+		IndexFiles:     []string{"index", "README"},
+		Cache:          true,
+		Raw:            true,
+		Admonitions:    true,
+		Emoji:          true,
+		HeadingAnchors: true,
+		Images:         true,
+		ColorScheme:    "auto",
+		MermaidJS:      defaultMermaidJS,
+		Listen:         ":8000",
+		Output:         "public",
+		BlogPageSize:   10,
+		MaxPageSize:    64 << 20,
+		MaxStreams:     2,
+		RateBurst:      20,
+		ReferrerPolicy: "strict-origin-when-cross-origin",
+		FrameOptions:   "SAMEORIGIN",
+	}
+}
+
+// watchesTree reports whether the options need the content tree to be watched for changes.
+func (c Config) watchesTree() bool {
+	return c.Watch || c.GitURL != "" || c.Search || c.Nav || c.PrevNext || c.WikiLinks || c.Blog != "" || c.Tags || c.Recent > 0 || c.Redirects || c.Shortcodes
+}
+
+// bindFlags registers the options shared by all modes on fs, storing values in cfg.
+func bindFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.Var((*listFlag)(&cfg.MarkdownExts), "md-exts", "Comma-separated list of file extensions to render as markdown, the first one preferred")
+	fs.Var((*listFlag)(&cfg.IndexFiles), "index-files", "Comma-separated list of pages to render for a directory in order of preference, with any markdown extension unless one is given")
+	fs.Var((*listFlag)(&cfg.CSS), "css", "Comma-separated list of CSS source URLs to include")
+	fs.Var((*listFlag)(&cfg.JS), "js", "Comma-separated list of JS source URLs to include")
+	fs.StringVar(&cfg.Template, "template", cfg.Template, "Path to an HTML template replacing the built-in page template")
+	fs.StringVar(&cfg.TemplateDir, "template-dir", cfg.TemplateDir, `Directory of partial templates, each name.html usable in page templates as {{ template "name" . }}`)
+	fs.StringVar(&cfg.Theme, "theme", cfg.Theme, "Built-in stylesheet to include ("+strings.Join(themeNames(), ", ")+")")
+	fs.StringVar(&cfg.ColorScheme, "default-color-scheme", cfg.ColorScheme, "Color scheme of pages until visitors pick one with the toggle: auto to follow their system, light or dark")
+	fs.BoolVar(&cfg.AutoIndex, "autoindex", cfg.AutoIndex, "Render a directory listing for directories without an index page")
+	fs.BoolVar(&cfg.Cache, "cache", cfg.Cache, "Cache rendered markdown in memory until the file changes")
+	fs.BoolVar(&cfg.CleanURLs, "clean-urls", cfg.CleanURLs, "Serve page.md at /page and link to pages without the .md extension")
+	fs.BoolVar(&cfg.Mermaid, "mermaid", cfg.Mermaid, "Render mermaid code blocks as diagrams")
+	fs.StringVar(&cfg.MermaidJS, "mermaid-js", cfg.MermaidJS, "URL of the mermaid script included on pages with diagrams")
+	fs.BoolVar(&cfg.Math, "math", cfg.Math, "Render $...$ and $$...$$ math with KaTeX")
+	fs.BoolVar(&cfg.Admonitions, "admonitions", cfg.Admonitions, "Render > [!NOTE] alerts and :::note containers as callouts")
+	fs.BoolVar(&cfg.Emoji, "emoji", cfg.Emoji, "Render :smile: style emoji shortcodes as Unicode emoji")
+	fs.BoolVar(&cfg.HeadingAnchors, "heading-anchors", cfg.HeadingAnchors, "Append a # permalink to headings that copies the link to the section when clicked")
+	fs.Var((*listFlag)(&cfg.Extensions), "extensions", "Comma-separated list of markdown extensions to enable ("+strings.Join(optionalExtensionNames(), ", ")+")")
+	fs.BoolVar(&cfg.Search, "search", cfg.Search, "Enable full-text search at "+searchPath+" and add a search box to pages")
+	fs.BoolVar(&cfg.Nav, "nav", cfg.Nav, "Provide the navigation tree of the site to the template as .Nav")
+	fs.BoolVar(&cfg.PrevNext, "prev-next", cfg.PrevNext, "Provide the previous and next page in navigation order to the template as .Prev and .Next")
+	fs.BoolVar(&cfg.Raw, "raw", cfg.Raw, "Show the markdown source of pages requested with ?raw=1 or ?source=1")
+	fs.BoolVar(&cfg.JSON, "json", cfg.JSON, "Answer requests for pages with an Accept: application/json header or format=json with the rendered content, title, TOC, front matter and modification time as JSON")
+	fs.StringVar(&cfg.PDFCommand, "pdf-command", cfg.PDFCommand, "Converter serving pages requested with ?format=pdf as PDF, like \"wkhtmltopdf - -\" reading HTML on stdin or \"chromium --headless --print-to-pdf={output} {input}\"")
+	fs.BoolVar(&cfg.HTML, "html", cfg.HTML, "Render raw HTML embedded in markdown instead of omitting it")
+	fs.BoolVar(&cfg.Sanitize, "sanitize", cfg.Sanitize, "Sanitize rendered pages so untrusted markdown can't inject scripts")
+	fs.BoolVar(&cfg.Include, "include", cfg.Include, "Inline other markdown files with {{include \"file.md\"}} or <!-- include: file.md --> on a line of their own")
+	fs.BoolVar(&cfg.Shortcodes, "shortcodes", cfg.Shortcodes, "Expand {{< name args >}} with the template name.html in the "+shortcodeDirName+" directory of the base path")
+	fs.BoolVar(&cfg.Images, "images", cfg.Images, "Lazy load images and give the img tags the width and height of the PNG, JPEG and GIF files in the tree")
+	fs.BoolVar(&cfg.WikiLinks, "wiki-links", cfg.WikiLinks, "Link [[Page Name]] to the page of that name, with the \"missing\" class if there is none")
+	fs.StringVar(&cfg.SiteURL, "site-url", cfg.SiteURL, "Public URL of the site, used for absolute links in meta tags and the sitemap")
+	fs.StringVar(&cfg.SiteTitle, "site-title", cfg.SiteTitle, "Name of the site, used as the title of the feed")
+	fs.StringVar(&cfg.BaseURL, "base-url", cfg.BaseURL, "URL path the site is served under behind a proxy that strips it, like /docs/, added to generated URLs and root-relative links in pages")
+	fs.BoolVar(&cfg.SecurityHeaders, "security-headers", cfg.SecurityHeaders, "Send Content-Security-Policy, X-Content-Type-Options, Referrer-Policy and X-Frame-Options headers with rendered pages")
+	fs.StringVar(&cfg.CSP, "csp", cfg.CSP, "Content-Security-Policy of rendered pages with --security-headers (default: same origin plus the origins of the page's CSS and JS)")
+	fs.StringVar(&cfg.ReferrerPolicy, "referrer-policy", cfg.ReferrerPolicy, "Referrer-Policy of rendered pages with --security-headers, empty to leave it out")
+	fs.StringVar(&cfg.FrameOptions, "frame-options", cfg.FrameOptions, "X-Frame-Options of rendered pages with --security-headers, empty to leave it out")
+	fs.StringVar(&cfg.EditBaseURL, "edit-base-url", cfg.EditBaseURL, "URL that pages' paths are appended to for their \"Edit this page\" link, like https://github.com/org/repo/edit/main/docs/")
+	fs.BoolVar(&cfg.Feed, "feed", cfg.Feed, "Publish an Atom feed of the newest pages, by front matter date or modification time, at "+feedPath)
+	fs.StringVar(&cfg.Blog, "blog", cfg.Blog, "Directory of dated posts to list newest first at its URL, with permalinks like /blog/2024/05/post/ (. for the root)")
+	fs.IntVar(&cfg.BlogPageSize, "blog-page-size", cfg.BlogPageSize, "Number of posts per page of the blog index")
+	fs.IntVar(&cfg.Recent, "recent", cfg.Recent, "Number of most recently modified pages to list at "+recentPath+" and in {{ .Recent }} (0 for none)")
+	fs.BoolVar(&cfg.Tags, "tags", cfg.Tags, "List pages by the tags and categories in their front matter at /tags/<tag>/ and /categories/<category>/")
+	fs.BoolVar(&cfg.Redirects, "redirects", cfg.Redirects, "Redirect the aliases: in the front matter of pages to them, and the URLs in "+redirectsFileName+" as it lists, like \"/old/* /new/:splat 302\" (301 by default)")
+	fs.BoolVar(&cfg.Sitemap, "sitemap", cfg.Sitemap, "Generate "+sitemapPath+" and, unless the tree has one, "+robotsPath)
+	fs.Var((*listFlag)(&cfg.Languages), "languages", "Comma-separated list of the languages of the site, the first being the default, serving page.<lang>.md or <lang>/page.md for page.md by Accept-Language or ?lang=")
+	fs.Var((*listFlag)(&cfg.RobotsDisallow), "robots-disallow", "Comma-separated list of paths the generated robots.txt disallows")
+	fs.BoolVar(&cfg.Drafts, "drafts", cfg.Drafts, "Serve and list pages marked draft: true or dated in the future, which are otherwise hidden")
+	fs.BoolVar(&cfg.Hidden, "hidden", cfg.Hidden, "Serve dotfiles and files starting with _, which are otherwise hidden apart from .well-known")
+	fs.BoolVar(&cfg.FollowSymlinks, "follow-symlinks", cfg.FollowSymlinks, "Serve files through symlinks pointing outside the base path")
+	fs.Int64Var(&cfg.MaxPageSize, "max-page-size", cfg.MaxPageSize, "Largest file in bytes rendered as a page, failing larger ones, as pages are held in memory while rendered (0 for no limit)")
+	fs.IntVar(&cfg.MaxStreams, "max-streams", cfg.MaxStreams, "Pages over 1 MiB rendered at once, which are streamed rather than cached but still held in memory as they are parsed (0 for no limit)")
+	fs.Var((*rendererFlag)(&cfg.Renderers), "renderers", "Comma-separated list of extension=renderer pairs rendering other files as pages, like .adoc=asciidoc,.rst=rst ("+strings.Join(rendererNames, ", ")+")")
+	fs.StringVar(&cfg.AsciidocCommand, "asciidoc-command", cfg.AsciidocCommand, "Command converting AsciiDoc on stdin to HTML on stdout for the asciidoc renderer (default \""+defaultAsciidocCommand+"\")")
+	fs.StringVar(&cfg.RSTCommand, "rst-command", cfg.RSTCommand, "Command converting reStructuredText on stdin to HTML on stdout for the rst renderer (default \""+defaultRSTCommand+"\")")
+	fs.StringVar(&cfg.WikiCreate, "wiki-create", cfg.WikiCreate, "URL that links to missing wiki pages point to, with the page name in the page query parameter")
+}
+
+// bindServerFlags registers the options that only apply when serving.
+func bindServerFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.Var((*mountFlag)(&cfg.Mounts), "mount", "Serve another tree as /path=root, may be repeated")
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, "Address to listen on when not running as CGI, host:port or unix:/path (ignored under systemd socket activation)")
+	fs.BoolVar(&cfg.Watch, "watch", cfg.Watch, "Reload open pages in the browser when files under the base path change")
+	fs.BoolVar(&cfg.Prerender, "prerender", cfg.Prerender, "Render every page in the background on start and SIGHUP, filling the cache and logging pages that fail")
+	fs.BoolVar(&cfg.ImageResize, "image-resize", cfg.ImageResize, "Serve copies of the images in the tree scaled down to 400, 800, 1200 or 1600 pixels wide at "+imagePath+"path?w=width, and offer them in srcset")
+	fs.StringVar(&cfg.ImageCache, "image-cache", cfg.ImageCache, "Directory to keep the images scaled down by --image-resize in (default: mdssr/images in the user cache directory)")
+	fs.BoolVar(&cfg.Editable, "editable", cfg.Editable, "Let authenticated users edit pages in the browser at "+editPath+"path/page.md, saving them to disk (needs --basic-auth or --htpasswd)")
+	fs.BoolVar(&cfg.EditCommit, "edit-commit", cfg.EditCommit, "Commit each page saved with --editable to the git repository of the base path")
+	fs.StringVar(&cfg.FastCGI, "fcgi", cfg.FastCGI, "Serve FastCGI on host:port, unix:/path or - for standard input instead of CGI or HTTP")
+	fs.StringVar(&cfg.GitURL, "git-url", cfg.GitURL, "Serve a checkout of this git repository, cloned into the base path (a temporary directory if none is given)")
+	fs.StringVar(&cfg.GitRef, "git-ref", cfg.GitRef, "Branch, tag or commit of --git-url to serve (default: the default branch)")
+	fs.DurationVar(&cfg.GitPull, "git-pull", cfg.GitPull, "Interval to pull --git-url at, like 5m (default: only on start and webhook calls)")
+	fs.StringVar(&cfg.GitWebhookSecret, "git-webhook-secret", cfg.GitWebhookSecret, "Secret of the GitHub or GitLab push webhook that pulls --git-url when POSTed to "+gitWebhookPath)
+	fs.BoolVar(&cfg.Compress, "compress", cfg.Compress, "Compress responses with brotli or gzip for clients that accept it")
+	fs.BoolVar(&cfg.Metrics, "metrics", cfg.Metrics, "Expose render, cache and request metrics for Prometheus at "+metricsPath)
+	fs.BoolVar(&cfg.Health, "health", cfg.Health, "Answer liveness checks at "+healthPath+" and readiness checks at "+readyPath)
+	fs.StringVar(&cfg.AdminListen, "admin-listen", cfg.AdminListen, "Address to serve the --metrics, --health and --admin-token endpoints on instead of the main one, host:port or unix:/path")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "Bearer token to require on POST "+adminPurgePath+"?path=/page and "+adminPurgeAllPath+", which drop rendered pages and rescan the tree (the endpoints are off without it)")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "Requests per second each client may send after a burst of --rate-burst, answering more with 429 (0 for no limit)")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "Requests each client may send at once under --rate-limit")
+	fs.BoolVar(&cfg.TrustProxy, "trust-proxy", cfg.TrustProxy, "Identify clients by the address the proxy in front adds to X-Forwarded-For")
+	fs.IntVar(&cfg.MaxURL, "max-url", cfg.MaxURL, "Longest request URL in bytes, answering longer ones with 414 (0 for no limit)")
+	fs.Int64Var(&cfg.MaxBody, "max-body", cfg.MaxBody, "Largest request body in bytes, answering larger ones with 413 (0 for no limit)")
+	fs.StringVar(&cfg.AccessLog, "access-log", cfg.AccessLog, "Log every request to standard error in this format, text or json")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "Path to a TLS certificate file to serve HTTPS with")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "Path to the TLS key file matching --tls-cert")
+	fs.Var((*listFlag)(&cfg.Autocert), "autocert", "Comma-separated list of domains to get Let's Encrypt certificates for (the listen address must be reachable on port 443)")
+	fs.StringVar(&cfg.AutocertCache, "autocert-cache", cfg.AutocertCache, "Directory to keep autocert certificates in (default: mdssr/autocert in the user cache directory)")
+	fs.Var((*listFlag)(&cfg.BasicAuth), "basic-auth", "Comma-separated list of user:pass credentials required to read pages")
+	fs.StringVar(&cfg.Htpasswd, "htpasswd", cfg.Htpasswd, "Path to an htpasswd file with bcrypt or SHA hashed credentials required to read pages")
+}
+
+// bindBuildFlags registers the options that only apply to the build subcommand.
+func bindBuildFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Output, "o", cfg.Output, "Output directory for the generated site")
+}
+
+// bindCheckFlags registers the options that only apply to the check subcommand.
+func bindCheckFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.BoolVar(&cfg.Strict, "strict", cfg.Strict, "Fail on orphaned pages too, not only on broken links and missing images")
+}
+
+// configFlags registers the options on fs using the given bind functions, plus --config.
+// The returned function loads the config file once fs has been parsed and applies
+// the flags that were set on the command line on top of it.
+func configFlags(fs *flag.FlagSet, binds ...func(*flag.FlagSet, *Config)) func(basePath string) (Config, error) {
+	flagCfg := DefaultConfig()
+	for _, bind := range binds {
+		bind(fs, &flagCfg)
+	}
+	configFlag := fs.String("config", "", "Path to a config file (default: "+configFileName+" in the base path, if present and not a --git-url checkout)")
+
+	return func(basePath string) (Config, error) {
+		cfg := DefaultConfig()
+
+		// The config file of a --git-url checkout is pushed to the repository
+		// like the pages, so it isn't trusted with commands and server options
+		path, discovered := *configFlag, false
+		if gitURL := fs.Lookup("git-url"); path == "" && (gitURL == nil || gitURL.Value.String() == "") {
+			if candidate := filepath.Join(basePath, configFileName); fileExists(candidate) {
+				path, discovered = candidate, true
+			}
+		}
+		if path != "" {
+			if err := loadConfigFile(path, &cfg); err != nil {
+				return Config{}, err
+			}
+		}
+		if discovered && cfg.GitURL != "" {
+			return Config{}, fmt.Errorf("%s: git-url must be given on the command line or in a --config file outside the checkout", path)
+		}
+
+		// Flags given on the command line override the config file
+		apply := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+		for _, bind := range binds {
+			bind(apply, &cfg)
+		}
+		var err error
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "config" || err != nil {
+				return
+			}
+			err = apply.Set(f.Name, f.Value.String())
+		})
+		if err != nil {
+			return Config{}, err
+		}
+
+		if err := cfg.normalize(); err != nil {
+			return Config{}, err
+		}
+		return cfg, nil
+	}
+}
+
+// normalize checks the options, putting extensions in the form they are
+// compared in.
+func (c *Config) normalize() error {
+	if err := validateExtensions(c.Extensions); err != nil {
+		return err
+	}
+	var err error
+	if c.Renderers, err = normalizeRenderers(c.Renderers); err != nil {
+		return err
+	}
+	if !slices.Contains(colorSchemes, c.ColorScheme) {
+		return fmt.Errorf("unknown color scheme %q, available color schemes: %s", c.ColorScheme, strings.Join(colorSchemes, ", "))
+	}
+	if len(c.MarkdownExts) == 0 {
+		return errors.New("--md-exts needs at least one extension")
+	}
+	c.MarkdownExts = slices.Clone(c.MarkdownExts)
+	for i, ext := range c.MarkdownExts {
+		if !strings.HasPrefix(ext, ".") {
+			c.MarkdownExts[i] = "." + ext
+		}
+	}
+	if c.Editable && len(c.BasicAuth) == 0 && c.Htpasswd == "" {
+		return errors.New("--editable needs users to authenticate with --basic-auth or --htpasswd")
+	}
+	if c.BaseURL = strings.TrimSuffix(c.BaseURL, "/"); c.BaseURL != "" && !strings.HasPrefix(c.BaseURL, "/") {
+		return fmt.Errorf("--base-url %q must be a path starting with /", c.BaseURL)
+	}
+	for i := range c.Mounts {
+		if err := c.Mounts[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadConfigFile reads the YAML config file at path into cfg.
+// Relative paths in the file are resolved against the file's directory.
+func loadConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	paths := []*string{&cfg.Template, &cfg.TemplateDir, &cfg.Output, &cfg.Htpasswd, &cfg.TLSCert, &cfg.TLSKey, &cfg.AutocertCache}
+	for i := range cfg.Mounts {
+		paths = append(paths, &cfg.Mounts[i].Root, &cfg.Mounts[i].Template)
+	}
+	for _, p := range paths {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return nil
+}
+
+// listFlag is a flag.Value for a comma-separated list of strings.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = parseSources(s)
+	return nil
+}
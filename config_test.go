@@ -0,0 +1,54 @@
+package mdssr
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFlagsGitCheckout(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		configFileName: "pdf-command: touch pwned\nsite-title: Pushed\n",
+	})
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"site.yaml": "git-url: https://example.com/docs.git\n"})
+	outside := filepath.Join(dir, "site.yaml")
+
+	tests := []struct {
+		name  string
+		args  []string
+		title string
+	}{
+		{"config file of the tree", nil, "Pushed"},
+		{"config file of a checkout", []string{"-git-url", "https://example.com/docs.git"}, ""},
+		{"git url from --config", []string{"-config", outside}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("mdssr", flag.ContinueOnError)
+			load := configFlags(fs, bindFlags, bindServerFlags)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := load(base)
+			if err != nil {
+				t.Fatalf("loading config with %v: %v", tt.args, err)
+			}
+			if cfg.SiteTitle != tt.title {
+				t.Errorf("site title with %v = %q, want %q", tt.args, cfg.SiteTitle, tt.title)
+			}
+			if tt.title == "" && cfg.PDFCommand != "" {
+				t.Errorf("pdf command with %v = %q, want none", tt.args, cfg.PDFCommand)
+			}
+		})
+	}
+
+	// A git URL in the config file of the tree would have it replaced by the checkout
+	writeFiles(t, base, map[string]string{configFileName: "git-url: https://example.com/docs.git\n"})
+	fs := flag.NewFlagSet("mdssr", flag.ContinueOnError)
+	load := configFlags(fs, bindFlags, bindServerFlags)
+	if _, err := load(base); err == nil {
+		t.Error("loading a config file in the base path setting git-url succeeded")
+	}
+}
@@ -0,0 +1,310 @@
+package mdssr
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark/parser"
+)
+
+// editPath is the URL prefix under which --editable serves the editor of the
+// pages, as /_edit/path/to/page.md.
+const editPath = "/_edit/"
+
+// URLs of the embedded script and stylesheet of the editor.
+const (
+	editScript     = assetsPrefix + "edit.js"
+	editStylesheet = assetsPrefix + "edit.css"
+)
+
+// editContentType is the media type of the markdown the editor sends. Forms
+// can't send it, and other sites can't without CORS.
+const editContentType = "text/markdown"
+
+// maxEditSize is the size of the largest page the editor saves when
+// --max-page-size is 0.
+const maxEditSize = 10 << 20
+
+// Template for the editor, which edit.js brings to life.
+const editTemplate = `<form class="editor" data-save="{{ .SaveURL }}" data-version="{{ .Version }}">
+    <div class="editor-panes">
+        <textarea name="source" spellcheck="false" aria-label="Markdown source">{{ .Source }}</textarea>
+        <iframe class="editor-preview" sandbox title="Preview"></iframe>
+    </div>
+    <p class="editor-actions">
+        <button type="submit">Save</button>
+        <a href="{{ .PageURL }}">View page</a>
+        <span class="editor-status" role="status"></span>
+    </p>
+</form>`
+
+var editTmpl = template.Must(template.New("edit").Parse(editTemplate))
+
+// editMu serializes saves, so the version check, write and commit of one save
+// aren't interleaved with those of another.
+var editMu sync.Mutex
+
+// serveEdit serves the editor of the page at the path after editPath to the
+// users who may read it: GET shows the editor, POST renders the markdown in the
+// body as a preview and PUT saves it. Visitors must authenticate even where the
+// access rules make the page public. Pages that don't exist yet are created on
+// save.
+//
+// Previews and saves must come from the site itself with the markdown sent as
+// editContentType, so other sites can't make a browser render or save pages
+// with its credentials. As previews may hold raw HTML, the editor shows them in
+// a sandboxed frame, where scripts don't run, and they are served sandboxed too.
+func (h *markdownHandler) serveEdit(w http.ResponseWriter, r *http.Request) {
+	urlPath := "/" + strings.TrimPrefix(r.URL.Path, editPath)
+	user, ok := h.auth.authenticate(r)
+	if !ok || !h.auth.allowed(r, urlPath) {
+		challenge(w)
+		return
+	}
+	if !h.cfg.isMarkdown(urlPath) || slices.ContainsFunc(strings.Split(urlPath, "/"), h.cfg.isHidden) {
+		h.notFound(w, r)
+		return
+	}
+	file, err := h.containedPath(filepath.Join(h.basePath, filepath.FromSlash(urlPath)))
+	if err != nil || filepath.Base(file) == accessFileName || isOverrideFile(file) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if crossSite(r) {
+			http.Error(w, "Forbidden: cross-site request", http.StatusForbidden)
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != editContentType {
+			http.Error(w, "Unsupported Media Type: send the page as "+editContentType, http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.serveEditor(w, r, file, urlPath)
+	case http.MethodPost:
+		h.servePreview(w, r, file)
+	case http.MethodPut:
+		h.saveEdit(w, r, file, user)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, PUT")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveEditor serves the editor of the markdown file at file, served at
+// urlPath, which is empty if the file doesn't exist yet.
+func (h *markdownHandler) serveEditor(w http.ResponseWriter, r *http.Request, file, urlPath string) {
+	src, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		h.serverError(w, r, "Unable to read file")
+		log.Printf("Error reading file %s: %v\n", file, err)
+		return
+	}
+	version := ""
+	if info, err := os.Stat(file); err == nil {
+		version = fileVersion(info)
+	}
+
+	rel := strings.TrimPrefix(urlPath, "/")
+	var buf bytes.Buffer
+	err = editTmpl.Execute(&buf, struct {
+		SaveURL, PageURL, Version, Source string
+	}{
+		SaveURL: h.prefix + editPath + (&url.URL{Path: rel}).EscapedPath(),
+		PageURL: h.pageURL(rel),
+		Version: version,
+		Source:  string(src),
+	})
+	if err != nil {
+		h.serverError(w, r, "Error rendering editor")
+		log.Printf("Error executing edit template: %v\n", err)
+		return
+	}
+
+	data := PageData{
+		Title:   "Editing " + rel,
+		Content: template.HTML(buf.String()),
+		CSS:     []string{editStylesheet},
+		JS:      []string{editScript},
+		dir:     filepath.Dir(file),
+	}
+	// The editor holds the source as it was when opened
+	w.Header().Set("Cache-Control", "no-store")
+	h.renderPage(w, r, urlPath, data, time.Time{})
+}
+
+// servePreview renders the markdown in the body of r, a draft of the file at
+// file, as HTML without the page template.
+func (h *markdownHandler) servePreview(w http.ResponseWriter, r *http.Request, file string) {
+	src, ok := h.readEdit(w, r)
+	if !ok {
+		return
+	}
+	_, body, err := parseFrontMatter(src)
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	ctx := parser.NewContext(parser.WithIDs(newSlugIDs()))
+	if err := h.rendererFor(h.layoutFor(filepath.Dir(file)), file).Render(&buf, body, ctx); err != nil {
+		http.Error(w, "Error rendering preview: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	content := buf.Bytes()
+	if h.sanitize != nil {
+		content = h.sanitize.SanitizeBytes(content)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(content)
+}
+
+// crossSite reports whether r was sent by a page of another site, going by the
+// Sec-Fetch-Site header of browsers, or in older ones the Origin header.
+func crossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != r.Host
+	}
+	return false
+}
+
+// saveEdit replaces the file at file with the body of r, saved by user. Saves
+// carrying an If-Match header with the version the editor was opened with, or
+// If-None-Match: * for new pages, fail with 412 if the file changed since. With
+// --edit-commit, the file is then committed to the git repository it's in.
+func (h *markdownHandler) saveEdit(w http.ResponseWriter, r *http.Request, file, user string) {
+	src, ok := h.readEdit(w, r)
+	if !ok {
+		return
+	}
+	if _, _, err := parseFrontMatter(src); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	editMu.Lock()
+	defer editMu.Unlock()
+
+	info, err := os.Stat(file)
+	exists := err == nil
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || match != fileVersion(info)) ||
+		r.Header.Get("If-None-Match") == "*" && exists {
+		http.Error(w, "Precondition Failed: the page changed since it was opened", http.StatusPreconditionFailed)
+		return
+	}
+
+	if err := writeFileAtomic(file, src); err != nil {
+		h.serverError(w, r, "Unable to save file")
+		log.Printf("Error saving %s: %v\n", file, err)
+		return
+	}
+	log.Printf("Saved %s, edited by %s\n", file, user)
+	if h.cfg.EditCommit {
+		if err := commitEdit(h.basePath, file, user); err != nil {
+			log.Printf("Error committing %s: %v\n", file, err)
+		}
+	}
+	h.contentChanged()
+
+	if info, err := os.Stat(file); err == nil {
+		w.Header().Set("ETag", fileVersion(info))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readEdit reads the body of r, a page sent by the editor, answering with 413
+// if it's larger than --max-page-size, or maxEditSize if there is no limit.
+func (h *markdownHandler) readEdit(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	src, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cmp.Or(h.cfg.MaxPageSize, maxEditSize)))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, "Content Too Large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	case err != nil:
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil, false
+	}
+	return src, true
+}
+
+// fileVersion identifies the state of a file by its modification time and size,
+// so the editor can tell whether it changed since it was opened.
+func fileVersion(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// writeFileAtomic replaces the file at path with data, writing it to a hidden
+// file next to it first so readers never see it half written. New files are
+// created along with their directory.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// commitEdit commits the file at file, saved in the editor by user, to the git
+// repository dir is in.
+func commitEdit(dir, file, user string) error {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return err
+	}
+	if _, err := runGit(dir, "add", "--", rel); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Edit %s\n\nSaved by %s in the mdssr editor.", filepath.ToSlash(rel), user)
+	_, err = runGit(dir, "commit", "--quiet", "--message", message, "--", rel)
+	return err
+}
@@ -0,0 +1,82 @@
+package mdssr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEditor(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"page.md": "# Page\n",
+	})
+	cfg := DefaultConfig()
+	cfg.Editable = true
+	cfg.HTML = true
+	cfg.BasicAuth = []string{"alice:secret"}
+	h, err := newMarkdownHandler(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := get(h, editPath+"page.md", "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %spage.md = %d", editPath, w.Code)
+	}
+	// Previews may hold scripts, so they must stay out of the editor page
+	if body := w.Body.String(); !strings.Contains(body, `<iframe class="editor-preview" sandbox`) {
+		t.Errorf("editor doesn't show the preview in a sandboxed frame:\n%s", body)
+	}
+	w = get(h, editScript, "")
+	if script := w.Body.String(); strings.Contains(script, "innerHTML") || !strings.Contains(script, "preview.srcdoc") {
+		t.Errorf("%s doesn't put the preview in the frame:\n%s", editScript, script)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		user        string
+		contentType string
+		site        string
+		status      int
+	}{
+		{"preview", http.MethodPost, "alice", "text/markdown; charset=utf-8", "same-origin", http.StatusOK},
+		{"preview without credentials", http.MethodPost, "", "text/markdown", "same-origin", http.StatusUnauthorized},
+		{"preview from another site", http.MethodPost, "alice", "text/markdown", "cross-site", http.StatusForbidden},
+		{"preview from a form", http.MethodPost, "alice", "text/plain", "same-origin", http.StatusUnsupportedMediaType},
+		{"save from another site", http.MethodPut, "alice", "text/markdown", "same-site", http.StatusForbidden},
+		{"save from a form", http.MethodPut, "alice", "application/x-www-form-urlencoded", "same-origin", http.StatusUnsupportedMediaType},
+		{"save", http.MethodPut, "alice", "text/markdown", "same-origin", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "# Edited\n\n<img src=x onerror=alert(1)>\n"
+			r := httptest.NewRequest(tt.method, editPath+"page.md", strings.NewReader(src))
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, "secret")
+			}
+			r.Header.Set("Content-Type", tt.contentType)
+			r.Header.Set("Sec-Fetch-Site", tt.site)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("%s %spage.md = %d, want %d: %s", tt.method, editPath, w.Code, tt.status, w.Body)
+			}
+
+			saved, err := os.ReadFile(filepath.Join(base, "page.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if edited := string(saved) == src; edited != (tt.method == http.MethodPut && w.Code == http.StatusNoContent) {
+				t.Errorf("%s %spage.md = %d left the page as %q", tt.method, editPath, w.Code, saved)
+			}
+			if tt.method == http.MethodPost && w.Code == http.StatusOK && w.Header().Get("Content-Security-Policy") != "sandbox" {
+				t.Errorf("preview served with Content-Security-Policy %q, want sandbox", w.Header().Get("Content-Security-Policy"))
+			}
+		})
+	}
+}
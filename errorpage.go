@@ -0,0 +1,72 @@
+package mdssr
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// notFound responds with the site's 404.md page, or a plain 404 if there is none.
+func (h *markdownHandler) notFound(w http.ResponseWriter, r *http.Request) {
+	if !h.renderError(w, r, http.StatusNotFound) {
+		http.NotFound(w, r)
+	}
+}
+
+// serverError responds with the site's 500.md page, or with msg if there is none.
+func (h *markdownHandler) serverError(w http.ResponseWriter, r *http.Request, msg string) {
+	if !h.renderError(w, r, http.StatusInternalServerError) {
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+// renderError renders the error page for status, named like 404.md, with that status.
+// The page is looked up in the requested directory and then at the root.
+// It reports whether an error page was found and written.
+func (h *markdownHandler) renderError(w http.ResponseWriter, r *http.Request, status int) bool {
+	dir := r.URL.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+
+	var candidates []string
+	for _, d := range []string{dir, "/"} {
+		for _, ext := range h.cfg.pageExts() {
+			candidates = append(candidates, path.Join(d, strconv.Itoa(status)+ext))
+		}
+	}
+	for _, candidate := range candidates {
+		file, err := h.containedPath(filepath.Join(h.basePath, candidate))
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		page, err := h.loadPage(file, info)
+		if err != nil {
+			log.Printf("Error rendering error page %s: %v\n", file, err)
+			return false
+		}
+		data := page.pageData()
+		body, err := h.executeFor(r, data)
+		if err != nil {
+			log.Printf("Error executing template for %s: %v\n", file, err)
+			return false
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		h.setSecurityHeaders(w, data)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+		return true
+	}
+	return false
+}
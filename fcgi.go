@@ -0,0 +1,27 @@
+package mdssr
+
+import (
+	"log"
+	"net/http"
+	"net/http/fcgi"
+)
+
+// serveFastCGI serves handler as a FastCGI application on addr.
+// addr is host:port for TCP, unix:/path for a Unix socket, or - for the
+// socket the web server passes on standard input.
+func serveFastCGI(addr string, handler http.Handler) error {
+	if addr == "-" {
+		log.Println("Serving FastCGI on standard input")
+		ready.Store(true)
+		return fcgi.Serve(nil, handler)
+	}
+
+	l, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	log.Printf("Serving FastCGI on %s\n", addr)
+	ready.Store(true)
+	return fcgi.Serve(l, handler)
+}
@@ -0,0 +1,107 @@
+package mdssr
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"time"
+)
+
+// feedPath is the URL of the Atom feed.
+const feedPath = "/feed.xml"
+
+// maxFeedEntries is the number of pages listed in the feed.
+const maxFeedEntries = 20
+
+// atomFeed is an Atom 1.0 feed.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Summary string      `xml:"summary,omitempty"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// feed returns the Atom feed of the newest pages that pass allowed.
+// origin is the scheme and host the URLs in the feed are relative to.
+func (h *markdownHandler) feed(origin string, allowed func(url string) bool) ([]byte, error) {
+	pages, err := h.listPages(allowed)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) > maxFeedEntries {
+		pages = pages[:maxFeedEntries]
+	}
+
+	home := origin + h.prefix + "/"
+	feed := atomFeed{
+		Title: h.cfg.SiteTitle,
+		ID:    home,
+		Links: []atomLink{{Href: origin + h.prefix + feedPath, Rel: "self"}, {Href: home}},
+	}
+	if feed.Title == "" {
+		feed.Title = home
+	}
+
+	var updated time.Time
+	for _, page := range pages {
+		entry := atomEntry{
+			Title:   page.Title,
+			ID:      origin + page.URL,
+			Link:    atomLink{Href: origin + page.URL},
+			Updated: page.Date.UTC().Format(time.RFC3339),
+			Summary: page.Description,
+		}
+		if page.Author != "" {
+			entry.Author = &atomAuthor{Name: page.Author}
+		}
+		feed.Entries = append(feed.Entries, entry)
+		if page.ModTime.After(updated) {
+			updated = page.ModTime
+		}
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// serveFeed serves the Atom feed, listing only the pages the visitor may read.
+func (h *markdownHandler) serveFeed(w http.ResponseWriter, r *http.Request) {
+	data, err := h.feed(h.siteOrigin(r), func(url string) bool { return h.allowed(r, url) })
+	if err != nil {
+		h.serverError(w, r, "Error generating feed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,127 @@
+package mdssr
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter holds the metadata from a page's YAML front matter.
+type FrontMatter struct {
+	Title       string    `yaml:"title"`
+	Weight      int       `yaml:"weight"`
+	Description string    `yaml:"description"`
+	Image       string    `yaml:"image"`
+	Author      string    `yaml:"author"`
+	Date        time.Time `yaml:"date"`
+	Tags        termList  `yaml:"tags"`
+	Categories  termList  `yaml:"categories"`
+	// Aliases are the old URLs of the page, redirected to it with --redirects.
+	Aliases []string `yaml:"aliases"`
+	// CSS and JS are stylesheets and scripts only this page includes, after the
+	// configured ones, and Head raw HTML added to the head of the page. JS and
+	// Head are ignored with --sanitize, as they'd let pages run scripts.
+	CSS  termList `yaml:"css"`
+	JS   termList `yaml:"js"`
+	Head string   `yaml:"head"`
+	// Draft pages and pages dated in the future are only served with --drafts.
+	Draft bool `yaml:"draft"`
+
+	// Params holds every key in the front matter, including the ones above.
+	Params map[string]any `yaml:"-"`
+}
+
+// termList is a list of tags, categories or URLs. In front matter it is either
+// a YAML list or a single value.
+type termList []string
+
+func (l *termList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = termList{value.Value}
+		return nil
+	}
+	return value.Decode((*[]string)(l))
+}
+
+// parseFrontMatter splits YAML front matter delimited by --- lines off the top of src.
+// If src has no front matter, the zero FrontMatter and src itself are returned.
+func parseFrontMatter(src []byte) (FrontMatter, []byte, error) {
+	var fm FrontMatter
+
+	rest, ok := cutLine(src, "---")
+	if !ok {
+		return fm, src, nil
+	}
+
+	// Find the closing delimiter
+	for offset := 0; offset < len(rest); {
+		line, _, _ := bytes.Cut(rest[offset:], []byte("\n"))
+		next := min(offset+len(line)+1, len(rest))
+		if delim := string(bytes.TrimRight(line, " \t\r")); delim == "---" || delim == "..." {
+			return decodeFrontMatter(src, rest[:offset], rest[next:])
+		}
+		offset = next
+	}
+	return fm, src, nil
+}
+
+// decodeFrontMatter decodes the YAML front matter raw of src, followed by body.
+// Content between --- lines that isn't a YAML mapping is a thematic break and a
+// setext heading rather than front matter, so src is returned unchanged.
+func decodeFrontMatter(src, raw, body []byte) (FrontMatter, []byte, error) {
+	var fm FrontMatter
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return fm, body, fmt.Errorf("parsing front matter: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return fm, body, nil
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return fm, src, nil
+	}
+
+	if err := doc.Decode(&fm); err != nil {
+		return fm, body, fmt.Errorf("parsing front matter: %w", err)
+	}
+	if err := doc.Decode(&fm.Params); err != nil {
+		return fm, body, fmt.Errorf("parsing front matter: %w", err)
+	}
+	return fm, body, nil
+}
+
+// cutLine reports whether the first line of src is line, ignoring trailing
+// whitespace, and returns the remainder after it.
+func cutLine(src []byte, line string) ([]byte, bool) {
+	first, rest, found := bytes.Cut(src, []byte("\n"))
+	if !found || string(bytes.TrimRight(first, " \t\r")) != line {
+		return src, false
+	}
+	return rest, true
+}
+
+// readMarkdown reads the markdown file at path and splits off its front matter.
+func readMarkdown(path string) (FrontMatter, []byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return FrontMatter{}, nil, err
+	}
+	return parseFrontMatter(src)
+}
+
+// published reports whether the page with front matter fm is served and listed:
+// always with drafts, or else when it isn't a draft or dated in the future.
+func (c Config) published(fm FrontMatter) bool {
+	return c.Drafts || !fm.Draft && !fm.Date.After(time.Now())
+}
+
+// pageTitle returns the title from the front matter, or else the first header in body.
+func pageTitle(fm FrontMatter, body []byte) (string, bool) {
+	if fm.Title != "" {
+		return fm.Title, true
+	}
+	return findTitle(body)
+}
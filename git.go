@@ -0,0 +1,227 @@
+package mdssr
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitWebhookPath is where pushes to the repository given with --git-url are reported.
+const gitWebhookPath = "/_git/pull"
+
+// gitTimeout limits how long a single git command may run.
+const gitTimeout = 5 * time.Minute
+
+// Commit is the last commit changing a page.
+type Commit struct {
+	Hash   string
+	Author string
+	Date   time.Time
+}
+
+// ShortHash returns the abbreviated hash of the commit.
+func (c Commit) ShortHash() string {
+	if len(c.Hash) > 7 {
+		return c.Hash[:7]
+	}
+	return c.Hash
+}
+
+// runGit runs git with args in dir and returns its standard output.
+func runGit(dir string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// syncGit checks out ref of the repository at url in dir, cloning it if dir is
+// not a checkout yet. An empty ref is the remote's default branch. Blobs are
+// only fetched as they are checked out, but the history is kept for the commit
+// metadata of pages. It reports whether the checked out commit changed.
+func syncGit(dir, url, ref string) (bool, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := runGit(dir, "rev-parse", "--git-dir"); err != nil {
+		if _, err := runGit(filepath.Dir(dir), "init", "-q", dir); err != nil {
+			return false, err
+		}
+	}
+	before, _ := runGit(dir, "rev-parse", "HEAD")
+	if _, err := runGit(dir, "fetch", "-q", "--filter=blob:none", url, ref); err != nil {
+		return false, err
+	}
+	if _, err := runGit(dir, "checkout", "-q", "--force", "FETCH_HEAD"); err != nil {
+		return false, err
+	}
+	after, err := runGit(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(before, after), nil
+}
+
+// gitPuller keeps a checkout in sync with its remote, on an interval and when
+// the webhook is called. Pulls never overlap.
+type gitPuller struct {
+	dir, url, ref string
+	secret        string
+	requests      chan struct{}
+}
+
+// newGitPuller returns a puller for the checkout of cfg.GitURL in dir and starts it.
+func newGitPuller(dir string, cfg Config) *gitPuller {
+	p := &gitPuller{dir: dir, url: cfg.GitURL, ref: cfg.GitRef, secret: cfg.GitWebhookSecret, requests: make(chan struct{}, 1)}
+	go p.run(cfg.GitPull)
+	return p
+}
+
+// run pulls whenever asked to and every interval, if it is positive.
+// Changed files are picked up by watching the tree.
+func (p *gitPuller) run(interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-p.requests:
+		}
+		changed, err := syncGit(p.dir, p.url, p.ref)
+		if err != nil {
+			log.Printf("Error pulling %s: %v\n", p.url, err)
+		} else if changed {
+			log.Printf("Pulled %s\n", p.url)
+		}
+	}
+}
+
+// ServeHTTP answers push webhooks by pulling in the background. Requests must be
+// signed with the secret the way GitHub does, or carry it the way GitLab does.
+func (p *gitPuller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil || !p.verify(r, body) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	select {
+	case p.requests <- struct{}{}:
+	default:
+		// A pull is already pending
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verify reports whether the webhook request r with body was sent with the secret.
+func (p *gitPuller) verify(r *http.Request, body []byte) bool {
+	if token := r.Header.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(p.secret)) == 1
+	}
+	signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(p.secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// gitHistory maps the files of a checkout to the last commit changing them.
+// It is read on first use and again after the tree changes, as it does when
+// pulled, so pages don't run git.
+type gitHistory struct {
+	dir string
+
+	mu      sync.Mutex
+	stale   bool
+	commits map[string]Commit
+}
+
+// invalidate marks the history for reading again on next use.
+func (g *gitHistory) invalidate() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stale = true
+}
+
+// lastCommit returns the last commit changing file, a path in the checkout.
+func (g *gitHistory) lastCommit(file string) (Commit, bool) {
+	rel, err := filepath.Rel(g.dir, file)
+	if err != nil {
+		return Commit{}, false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stale {
+		if g.commits, err = readHistory(g.dir); err != nil {
+			log.Printf("Error reading the git history of %s: %v\n", g.dir, err)
+		}
+		g.stale = false
+	}
+	commit, ok := g.commits[filepath.ToSlash(rel)]
+	return commit, ok
+}
+
+// readHistory returns the last commit changing each file of the checkout in dir,
+// keyed by the file's slash-separated path relative to dir.
+func readHistory(dir string) (map[string]Commit, error) {
+	prefix, err := runGit(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	out, err := runGit(dir, "log", "--format=%x00%H%x00%an%x00%aI", "--name-only", "--no-renames", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make(map[string]Commit)
+	var current Commit
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Split(line, "\x00"); len(fields) == 4 {
+			date, _ := time.Parse(time.RFC3339, fields[3])
+			current = Commit{Hash: fields[1], Author: fields[2], Date: date}
+			continue
+		}
+		// Paths are relative to the top of the repository
+		name, ok := strings.CutPrefix(line, strings.TrimSpace(string(prefix)))
+		if !ok || name == "" {
+			continue
+		}
+		if _, seen := commits[name]; !seen {
+			commits[name] = current
+		}
+	}
+	return commits, nil
+}
@@ -0,0 +1,46 @@
+package mdssr
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitHistory(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := runGit(dir, append([]string{"-c", "user.name=Alice", "-c", "user.email=alice@example.com"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+	commit := func(file string) string {
+		t.Helper()
+		writeTree(t, dir, file)
+		git("add", file)
+		git("commit", "-q", "-m", "Add "+file)
+		return git("rev-parse", "HEAD")[:40]
+	}
+	git("init", "-q")
+	first := commit("page.md")
+
+	g := &gitHistory{dir: dir, stale: true}
+	if c, ok := g.lastCommit(filepath.Join(dir, "page.md")); !ok || c.Hash != first || c.Author != "Alice" {
+		t.Errorf("last commit of page.md = %+v, %v, want %s by Alice", c, ok, first)
+	}
+
+	// Commits are only picked up once the tree changes
+	second := commit("other.md")
+	if _, ok := g.lastCommit(filepath.Join(dir, "other.md")); ok {
+		t.Error("history read again before it was invalidated")
+	}
+	g.invalidate()
+	if c, ok := g.lastCommit(filepath.Join(dir, "other.md")); !ok || c.Hash != second {
+		t.Errorf("last commit of other.md = %+v, %v, want %s", c, ok, second)
+	}
+}
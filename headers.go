@@ -0,0 +1,78 @@
+package mdssr
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// defaultCSP is the Content-Security-Policy of rendered pages unless --csp is
+// given, before the origins of the page's stylesheets and scripts are added.
+// Images and media may come from anywhere, as markdown often embeds them, and
+// inline styles are allowed for the diagrams mermaid draws.
+const defaultCSP = "default-src 'self'; img-src * data:; media-src *; style-src 'self' 'unsafe-inline'; script-src 'self'; font-src 'self' data:; connect-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'self'"
+
+// setSecurityHeaders sets the security headers of the rendered page data on w.
+func (h *markdownHandler) setSecurityHeaders(w http.ResponseWriter, data PageData) {
+	if !h.cfg.SecurityHeaders {
+		return
+	}
+	header := w.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	if h.cfg.ReferrerPolicy != "" {
+		header.Set("Referrer-Policy", h.cfg.ReferrerPolicy)
+	}
+	if h.cfg.FrameOptions != "" {
+		header.Set("X-Frame-Options", h.cfg.FrameOptions)
+	}
+	if csp := h.contentSecurityPolicy(data); csp != "" {
+		header.Set("Content-Security-Policy", csp)
+	}
+}
+
+// contentSecurityPolicy returns the configured policy, or the default one
+// allowing the origins of the stylesheets and scripts the page includes:
+// the configured ones of its directory and its own, like KaTeX's for math.
+func (h *markdownHandler) contentSecurityPolicy(data PageData) string {
+	if h.cfg.CSP != "" {
+		return h.cfg.CSP
+	}
+	l := h.rootLayout()
+	if data.dir != "" {
+		l = h.layoutFor(data.dir)
+	}
+	// KaTeX loads its fonts from next to its stylesheet
+	styles := assetOrigins(append(slices.Clone(l.cfg.CSS), data.CSS...))
+	scripts := assetOrigins(append(slices.Clone(l.cfg.JS), data.JS...))
+
+	directives := strings.Split(defaultCSP, "; ")
+	for i, directive := range directives {
+		name, _, _ := strings.Cut(directive, " ")
+		switch name {
+		case "style-src", "font-src":
+			directives[i] = strings.Join(append([]string{directive}, styles...), " ")
+		case "script-src":
+			directives[i] = strings.Join(append([]string{directive}, scripts...), " ")
+		}
+	}
+	return strings.Join(directives, "; ")
+}
+
+// assetOrigins returns the origins of the absolute URLs among urls, in order and without duplicates.
+func assetOrigins(urls []string) []string {
+	var origins []string
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		scheme := u.Scheme
+		if scheme == "" {
+			// Protocol-relative URLs
+			scheme = "https"
+		}
+		origins = appendMissing(origins, scheme+"://"+u.Host)
+	}
+	return origins
+}
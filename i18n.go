@@ -0,0 +1,155 @@
+package mdssr
+
+import (
+	"cmp"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Alternate is a translation of a page, linked with hreflang.
+type Alternate struct {
+	// Lang is a language of --languages, or x-default for the page without a
+	// language, which visitors get in their language.
+	Lang string
+	URL  string
+}
+
+// pageLanguage returns the language of the markdown file at file, given by a
+// suffix like page.zh.md or a subtree like zh/page.md, and the path of the page
+// without it, page.md. Files without a language belong to the default language.
+// It reports false for files without a language.
+func (h *markdownHandler) pageLanguage(file string) (lang, neutral string, ok bool) {
+	name := filepath.Base(file)
+	ext := h.cfg.markdownExt(name)
+	if lang := path.Ext(strings.TrimSuffix(name, ext)); lang != "" && slices.Contains(h.cfg.Languages, lang[1:]) {
+		return lang[1:], filepath.Join(filepath.Dir(file), strings.TrimSuffix(name, lang+ext)+ext), true
+	}
+	rel, err := filepath.Rel(h.basePath, file)
+	if err != nil {
+		return h.cfg.Languages[0], file, false
+	}
+	if first, rest, found := strings.Cut(filepath.ToSlash(rel), "/"); found && slices.Contains(h.cfg.Languages, first) {
+		return first, filepath.Join(h.basePath, filepath.FromSlash(rest)), true
+	}
+	return h.cfg.Languages[0], file, false
+}
+
+// languageVariant returns the file of the page at neutral, a page without a
+// language, in lang: page.lang.md, then lang/page.md, then for the default
+// language, the page itself. It reports false if there is no such file.
+func (h *markdownHandler) languageVariant(neutral, lang string) (string, bool) {
+	rel, err := filepath.Rel(h.basePath, neutral)
+	if err != nil {
+		return "", false
+	}
+	ext := h.cfg.markdownExt(neutral)
+	candidates := []string{
+		strings.TrimSuffix(neutral, ext) + "." + lang + ext,
+		filepath.Join(h.basePath, lang, rel),
+	}
+	if lang == h.cfg.Languages[0] {
+		candidates = append(candidates, neutral)
+	}
+	for _, candidate := range candidates {
+		if _, err := h.containedPath(candidate); err != nil {
+			continue
+		}
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// negotiateLanguage returns the file to render for a request for the markdown
+// file at file: its variant in the language given by ?lang=, or for pages
+// without a language, by the Accept-Language header. Files without a variant
+// in that language, or with one the visitor may not read, are rendered as they are.
+func (h *markdownHandler) negotiateLanguage(r *http.Request, file string, info os.FileInfo) (string, os.FileInfo) {
+	_, neutral, explicit := h.pageLanguage(file)
+	lang := r.URL.Query().Get("lang")
+	if !slices.Contains(h.cfg.Languages, lang) {
+		if explicit {
+			return file, info
+		}
+		lang = preferredLanguage(r.Header.Get("Accept-Language"), h.cfg.Languages)
+	}
+	variant, ok := h.languageVariant(neutral, lang)
+	if !ok || variant == file || !h.allowed(r, h.fileURL(variant)) {
+		return file, info
+	}
+	variantInfo, err := os.Stat(variant)
+	if err != nil {
+		return file, info
+	}
+	return variant, variantInfo
+}
+
+// alternates returns the translations of the markdown file at file, including
+// itself, for hreflang links, and the language of file.
+func (h *markdownHandler) alternates(file string) ([]Alternate, string) {
+	lang, neutral, _ := h.pageLanguage(file)
+	var alternates []Alternate
+	for _, l := range h.cfg.Languages {
+		if variant, ok := h.languageVariant(neutral, l); ok {
+			alternates = append(alternates, Alternate{Lang: l, URL: h.fileURL(variant)})
+		}
+	}
+	if len(alternates) < 2 {
+		return nil, lang
+	}
+	if fileExists(neutral) {
+		alternates = append(alternates, Alternate{Lang: "x-default", URL: h.fileURL(neutral)})
+	}
+	return alternates, lang
+}
+
+// fileURL returns the URL of the page at file, in the tree.
+func (h *markdownHandler) fileURL(file string) string {
+	rel, _ := filepath.Rel(h.basePath, file)
+	return h.pageURL(filepath.ToSlash(rel))
+}
+
+// preferredLanguage returns the language of languages that header, an
+// Accept-Language header, prefers, falling back to the first of languages.
+// Languages match by their primary subtag if there is no exact match, so zh-CN
+// picks zh, and zh picks zh-TW.
+func preferredLanguage(header string, languages []string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if tag != "" && q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	slices.SortStableFunc(tags, func(a, b weighted) int { return cmp.Compare(b.q, a.q) })
+
+	primary := func(tag string) string {
+		p, _, _ := strings.Cut(tag, "-")
+		return strings.ToLower(p)
+	}
+	for _, t := range tags {
+		if i := slices.IndexFunc(languages, func(l string) bool { return strings.EqualFold(l, t.tag) }); i >= 0 {
+			return languages[i]
+		}
+		if i := slices.IndexFunc(languages, func(l string) bool { return primary(l) == primary(t.tag) }); i >= 0 {
+			return languages[i]
+		}
+	}
+	return languages[0]
+}
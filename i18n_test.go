@@ -0,0 +1,58 @@
+package mdssr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateLanguageAccess(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"page.md":              "# Neutral\n",
+		"zh/page.md":           "# Chinese\n",
+		"zh/" + accessFileName: "users: [alice]\n",
+		"about.md":             "# About\n",
+		"about.zh.md":          "# About in Chinese\n",
+	})
+	cfg := DefaultConfig()
+	cfg.Languages = []string{"en", "zh"}
+	cfg.BasicAuth = []string{"alice:secret", "bob:secret"}
+	h, err := newMarkdownHandler(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target         string
+		acceptLanguage string
+		user           string
+		status         int
+		want           string
+	}{
+		{"/page.md?lang=zh", "", "alice", http.StatusOK, "Chinese"},
+		{"/page.md", "zh-CN", "alice", http.StatusOK, "Chinese"},
+		{"/page.md?lang=zh", "", "bob", http.StatusOK, "Neutral"},
+		{"/page.md", "zh-CN", "bob", http.StatusOK, "Neutral"},
+		{"/zh/page.md", "", "bob", http.StatusUnauthorized, ""},
+		{"/about.md?lang=zh", "", "bob", http.StatusOK, "About in Chinese"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target+" "+tt.acceptLanguage+" as "+tt.user, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.SetBasicAuth(tt.user, "secret")
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("GET %s as %s = %d, want %d", tt.target, tt.user, w.Code, tt.status)
+			}
+			if title := "<title>" + tt.want + "</title>"; tt.want != "" && !strings.Contains(w.Body.String(), title) {
+				t.Errorf("GET %s as %s doesn't contain %s:\n%s", tt.target, tt.user, title, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package mdssr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// imagePath is the URL prefix under which --image-resize serves scaled down
+// copies of the images in the tree, as /_img/path/to/image.png?w=800.
+const imagePath = "/_img/"
+
+// imageWidths are the widths images may be scaled down to.
+var imageWidths = []int{400, 800, 1200, 1600}
+
+// maxImagePixels is the size of the largest image that is resized, as decoding
+// takes memory in proportion, up to 200 MB at the limit. Scaling down only adds
+// the copy it makes.
+const maxImagePixels = 50_000_000
+
+var (
+	imgTagPattern  = regexp.MustCompile(`<img\b[^>]*>`)
+	imgSrcPattern  = regexp.MustCompile(`\ssrc="([^"]*)"`)
+	imgSizePattern = regexp.MustCompile(`\s(?:width|height)=`)
+)
+
+// rewriteImages adds lazy loading to the img tags in content, the rendered markdown
+// file at file, and the width and height of the images in the tree, so the page
+// doesn't shift as they load. With --image-resize, images wider than the smallest
+// of imageWidths also get a srcset of their scaled down copies.
+// The images measured are returned, as the page is stale once they change.
+func (h *markdownHandler) rewriteImages(content []byte, file string) ([]byte, []includedFile) {
+	var measured []includedFile
+	content = imgTagPattern.ReplaceAllFunc(content, func(tag []byte) []byte {
+		if bytes.Contains(tag, []byte(" loading=")) {
+			return tag
+		}
+		attrs := ` loading="lazy" decoding="async"`
+		if m := imgSrcPattern.FindSubmatch(tag); m != nil && !imgSizePattern.Match(tag) {
+			if size, img, ok := h.imageSize(file, string(m[1])); ok {
+				attrs += size
+				measured = append(measured, img)
+			}
+		}
+		return append([]byte("<img"+attrs), tag[len("<img"):]...)
+	})
+	return content, measured
+}
+
+// imageSize returns the width and height attributes, and with --image-resize the
+// srcset, of the image whose src attribute is src in the page at file, and the
+// image file. It reports false for images that aren't in the tree or can't be decoded.
+func (h *markdownHandler) imageSize(file, src string) (string, includedFile, bool) {
+	path, rel, ok := h.imageFile(file, html.UnescapeString(src))
+	if !ok {
+		return "", includedFile{}, false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", includedFile{}, false
+	}
+	config, err := imageConfig(path)
+	if err != nil {
+		return "", includedFile{}, false
+	}
+
+	attrs := fmt.Sprintf(` width="%d" height="%d"`, config.Width, config.Height)
+	if h.images != nil && config.Width > imageWidths[0] {
+		var srcset []string
+		for _, width := range imageWidths {
+			if width >= config.Width {
+				break
+			}
+			srcset = append(srcset, fmt.Sprintf("%s%s%s?w=%d %dw", h.prefix, imagePath, (&url.URL{Path: rel}).EscapedPath(), width, width))
+		}
+		srcset = append(srcset, fmt.Sprintf("%s %dw", src, config.Width))
+		attrs += fmt.Sprintf(` srcset="%s" sizes="(max-width: %dpx) 100vw, %dpx"`, strings.Join(srcset, ", "), config.Width, config.Width)
+	}
+	return attrs, includedFile{path: path, modTime: info.ModTime(), size: info.Size()}, true
+}
+
+// imageFile returns the file in the tree the image source src of the page at
+// file points to, and its slash-separated path relative to the base path.
+// It reports false for images on other sites and outside the tree.
+func (h *markdownHandler) imageFile(file, src string) (string, string, bool) {
+	u, err := url.Parse(src)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", "", false
+	}
+	path := filepath.Join(filepath.Dir(file), filepath.FromSlash(u.Path))
+	if strings.HasPrefix(u.Path, "/") {
+		urlPath, ok := strings.CutPrefix(u.Path, h.prefix+"/")
+		if !ok {
+			return "", "", false
+		}
+		path = filepath.Join(h.basePath, filepath.FromSlash(urlPath))
+	}
+	path, err = h.containedPath(path)
+	if err != nil {
+		return "", "", false
+	}
+	rel, err := filepath.Rel(h.basePath, path)
+	if err != nil || slices.ContainsFunc(strings.Split(filepath.ToSlash(rel), "/"), h.cfg.isHidden) {
+		return "", "", false
+	}
+	return path, filepath.ToSlash(rel), true
+}
+
+// imageConfig returns the dimensions of the PNG, JPEG or GIF image at path.
+func imageConfig(path string) (image.Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	return config, err
+}
+
+// serveImage serves the image at the path after imagePath scaled down to the
+// width given by the w query parameter, which must be one of imageWidths.
+// Images narrower than that are served as they are.
+func (h *markdownHandler) serveImage(w http.ResponseWriter, r *http.Request) {
+	urlPath := "/" + strings.TrimPrefix(r.URL.Path, imagePath)
+	if h.auth != nil && !h.auth.allowed(r, urlPath) {
+		challenge(w)
+		return
+	}
+	if slices.ContainsFunc(strings.Split(urlPath, "/"), h.cfg.isHidden) {
+		h.notFound(w, r)
+		return
+	}
+	file, err := h.containedPath(filepath.Join(h.basePath, filepath.FromSlash(urlPath)))
+	if err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	width, err := strconv.Atoi(r.URL.Query().Get("w"))
+	if err != nil || !slices.Contains(imageWidths, width) {
+		http.Error(w, fmt.Sprintf("Bad Request: w must be one of %s", strings.Trim(fmt.Sprint(imageWidths), "[]")), http.StatusBadRequest)
+		return
+	}
+
+	variant, err := h.images.variant(file, width)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		h.notFound(w, r)
+		return
+	case errors.Is(err, image.ErrFormat):
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		log.Printf("Error resizing %s: %v\n", file, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Shared caches mustn't hand images behind authentication to anyone
+	cacheControl := "public, max-age=86400"
+	if h.auth != nil {
+		cacheControl = "private, max-age=86400"
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeFile(w, r, variant)
+}
+
+// imageResizer scales down images, keeping the copies in a directory.
+type imageResizer struct {
+	dir string
+	// mu serializes resizing, which is heavy on memory and CPU.
+	mu sync.Mutex
+}
+
+// newImageResizer returns a resizer keeping its copies in dir, or mdssr/images
+// in the user cache directory if dir is empty.
+func newImageResizer(dir string) (*imageResizer, error) {
+	dir, err := userCacheDir(dir, "images")
+	if err != nil {
+		return nil, err
+	}
+	return &imageResizer{dir: dir}, nil
+}
+
+// variant returns the path of a copy of the image at file scaled down to width,
+// making it unless it was made since the image last changed. Images no wider
+// than width are their own variant.
+func (c *imageResizer) variant(file string, width int) (string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fs.ErrNotExist
+	}
+	config, err := imageConfig(file)
+	if err != nil {
+		return "", err
+	}
+	if config.Width <= width {
+		return file, nil
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return "", fmt.Errorf("image of %dx%d pixels is too large to resize", config.Width, config.Height)
+	}
+
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d", file, info.ModTime().UnixNano(), info.Size(), width)))
+	name := filepath.Join(c.dir, hex.EncodeToString(key[:16]))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ext := range []string{".jpg", ".png"} {
+		if fileExists(name + ext) {
+			return name + ext, nil
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	src, format, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+	bounds := src.Bounds()
+	dst := scaleDown(src, width, bounds.Dy()*width/bounds.Dx())
+
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return "", err
+	}
+	ext := ".png"
+	if format == "jpeg" {
+		ext = ".jpg"
+	}
+	tmp, err := os.CreateTemp(c.dir, "resize-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if ext == ".jpg" {
+		err = jpeg.Encode(tmp, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(tmp, dst)
+	}
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return name + ext, os.Rename(tmp.Name(), name+ext)
+}
+
+// scaleDown returns src scaled down to width by height, averaging the source
+// pixels each destination pixel covers. The pixels are read from src as they
+// are, so the decoded image isn't copied.
+func scaleDown(src image.Image, width, height int) image.Image {
+	bounds := src.Bounds()
+	at := func(x, y int) color.RGBA64 {
+		r, g, b, a := src.At(x, y).RGBA()
+		return color.RGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a)}
+	}
+	// The image types decoded by the standard library avoid allocating a color per pixel
+	if img, ok := src.(image.RGBA64Image); ok {
+		at = img.RGBA64At
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, max(width, 1), max(height, 1)))
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dw, dh := dst.Bounds().Dx(), dst.Bounds().Dy()
+	for y := range dh {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := range dw {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := at(bounds.Min.X+sx, bounds.Min.Y+sy)
+					r, g, b, a = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
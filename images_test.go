@@ -0,0 +1,37 @@
+package mdssr
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// opaqueImage hides the RGBA64At method of the image it wraps.
+type opaqueImage struct{ image.Image }
+
+func TestScaleDown(t *testing.T) {
+	red, blue := color.RGBA{R: 0xff, A: 0xff}, color.RGBA{B: 0xff, A: 0xff}
+	full := image.NewRGBA(image.Rect(0, 0, 6, 2))
+	for y := range 2 {
+		for x := range 6 {
+			c := red
+			if x >= 4 {
+				c = blue
+			}
+			full.Set(x, y, c)
+		}
+	}
+	// The right four pixels, half red and half blue, away from the origin
+	sub := full.SubImage(image.Rect(2, 0, 6, 2))
+	for name, src := range map[string]image.Image{"RGBA": sub, "Image": opaqueImage{sub}} {
+		dst := scaleDown(src, 2, 1)
+		if got := dst.Bounds(); got != image.Rect(0, 0, 2, 1) {
+			t.Fatalf("scaleDown of %s has bounds %v, want 2x1", name, got)
+		}
+		for x, want := range []color.RGBA{red, blue} {
+			if got := color.RGBAModel.Convert(dst.At(x, 0)); got != want {
+				t.Errorf("scaleDown of %s has %v at %d, want %v", name, got, x, want)
+			}
+		}
+	}
+}
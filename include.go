@@ -0,0 +1,146 @@
+package mdssr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxIncludeDepth limits how deeply included files may include further files.
+const maxIncludeDepth = 8
+
+// includePattern matches an include directive on a line of its own, either
+// {{include "file.md"}} or <!-- include: file.md -->.
+var includePattern = regexp.MustCompile(`^\s*(?:\{\{\s*include\s+"([^"]+)"\s*\}\}|<!--\s*include:\s*(\S+)\s*-->)\s*$`)
+
+// includedFile is a file inlined into a page. The page is stale once the file changes.
+type includedFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// changed reports whether the file has changed since it was included.
+func (f includedFile) changed() bool {
+	info, err := os.Stat(f.path)
+	return err != nil || !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+}
+
+// expandIncludes replaces the include directives in src, the body of the markdown
+// file at file, with the body of the files they name. Paths are relative to the
+// directory of file, or to the base path if they start with a slash.
+// Directives in fenced code blocks are left alone, and those naming files the
+// page may not include, see includable, fail. The included files are returned.
+func (h *markdownHandler) expandIncludes(file string, src []byte) ([]byte, []includedFile) {
+	var included []includedFile
+	return h.expandIncludesFrom([]string{file}, src, &included), included
+}
+
+// expandIncludesFrom expands the includes of the file at the top of stack, the
+// chain of files including each other.
+func (h *markdownHandler) expandIncludesFrom(stack []string, src []byte, included *[]includedFile) []byte {
+	file := stack[len(stack)-1]
+	var out bytes.Buffer
+	fence := ""
+	for len(src) > 0 {
+		line, rest, _ := bytes.Cut(src, []byte("\n"))
+		src = rest
+		trimmed := strings.TrimSpace(string(line))
+
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+			}
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+		default:
+			if m := includePattern.FindStringSubmatch(trimmed); m != nil {
+				body, err := h.includeFile(stack, m[1]+m[2], included)
+				if err != nil {
+					// Point visitors at the directive without the details of the file system
+					log.Printf("Error including %s in %s: %v\n", m[1]+m[2], file, err)
+					body = []byte(fmt.Sprintf("*Could not include %s*\n", m[1]+m[2]))
+				}
+				out.Write(body)
+				if len(body) > 0 && body[len(body)-1] != '\n' {
+					out.WriteByte('\n')
+				}
+				continue
+			}
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// includeFile returns the expanded body of the file named by an include directive
+// in the file at the top of stack.
+func (h *markdownHandler) includeFile(stack []string, name string, included *[]includedFile) ([]byte, error) {
+	target := filepath.Join(filepath.Dir(stack[len(stack)-1]), filepath.FromSlash(name))
+	if strings.HasPrefix(name, "/") {
+		target = filepath.Join(h.basePath, filepath.FromSlash(name))
+	}
+	target, err := h.containedPath(target)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.includable(stack[0], target); err != nil {
+		return nil, err
+	}
+	if slices.Contains(stack, target) {
+		return nil, errors.New("include cycle")
+	}
+	if len(stack) > maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested more than %d deep", maxIncludeDepth)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		// Keep the including page stale, so the file is picked up once it exists
+		*included = append(*included, includedFile{path: target})
+		return nil, err
+	}
+	fm, body, err := readMarkdown(target)
+	if err != nil {
+		return nil, err
+	}
+	*included = append(*included, includedFile{path: target, modTime: info.ModTime(), size: info.Size()})
+	if !h.cfg.published(fm) {
+		return nil, errors.New("not published")
+	}
+	return h.expandIncludesFrom(append(stack, target), body, included), nil
+}
+
+// includable checks that the page at file may include target: only markdown
+// files visitors could read themselves are included, and only if everyone who
+// may read the page may read them too.
+func (h *markdownHandler) includable(file, target string) error {
+	rel, err := filepath.Rel(h.basePath, target)
+	if err != nil {
+		return err
+	}
+	urlPath := "/" + filepath.ToSlash(rel)
+	if !h.cfg.isMarkdown(target) || slices.ContainsFunc(strings.Split(urlPath, "/"), h.cfg.isHidden) {
+		return errors.New("not a visible markdown file")
+	}
+	if h.auth == nil {
+		return nil
+	}
+	page, err := filepath.Rel(h.basePath, file)
+	if err != nil {
+		return err
+	}
+	if !h.auth.ruleFor(urlPath).admits(h.auth.ruleFor("/" + filepath.ToSlash(page))) {
+		return errors.New("access to it is more restricted than to the page")
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package mdssr
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark"
+)
+
+// Per-directory files overriding the template and options for their subtree.
+const (
+	layoutFileName    = "_layout.html"
+	dirConfigFileName = "_config.yaml"
+)
+
+// layout is what shapes the pages of a directory: the options, the page template
+// and the markdown pipeline built from the options. Below the base path, only the
+// options about rendering pages take effect, like css, js, theme, template and the
+// markdown extensions; site-wide ones like search or listen are left as they are.
+// Commands the server runs can't be set there, as the authors of the content
+// shouldn't choose them.
+// Overrides are read again when the tree changes, which needs watching.
+type layout struct {
+	cfg     Config
+	text    string
+	tmpl    *template.Template
+	md      goldmark.Markdown
+	version uint64
+}
+
+// layoutCache holds the layouts of directories with overrides in or above them.
+type layoutCache struct {
+	mu      sync.Mutex
+	layouts map[string]*layout
+}
+
+// invalidate drops every layout, so the override files are read again on next use.
+func (c *layoutCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.layouts = nil
+}
+
+// rootLayout returns the layout of the base path, from the handler's options.
+func (h *markdownHandler) rootLayout() *layout {
+	return &layout{cfg: h.cfg, text: h.tmplText, tmpl: h.tmpl, md: h.md, version: h.version}
+}
+
+// layoutFor returns the layout of dir, applying the _config.yaml and _layout.html
+// files of dir and its parents below the base path on top of the handler's options.
+func (h *markdownHandler) layoutFor(dir string) *layout {
+	rel, err := filepath.Rel(h.basePath, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return h.rootLayout()
+	}
+
+	h.layouts.mu.Lock()
+	l, ok := h.layouts.layouts[dir]
+	h.layouts.mu.Unlock()
+	if ok {
+		return l
+	}
+
+	parent := h.layoutFor(filepath.Dir(dir))
+	l, err = h.loadLayout(dir, parent)
+	if err != nil {
+		log.Printf("Error loading the layout of %s: %v\n", dir, err)
+		l = parent
+	}
+
+	h.layouts.mu.Lock()
+	if h.layouts.layouts == nil {
+		h.layouts.layouts = make(map[string]*layout)
+	}
+	h.layouts.layouts[dir] = l
+	h.layouts.mu.Unlock()
+	return l
+}
+
+// loadLayout returns the layout of dir given the layout of its parent, which is
+// returned as is if dir has no override files.
+func (h *markdownHandler) loadLayout(dir string, parent *layout) (*layout, error) {
+	configFile := filepath.Join(dir, dirConfigFileName)
+	layoutFile := filepath.Join(dir, layoutFileName)
+	hasConfig, hasLayout := fileExists(configFile), fileExists(layoutFile)
+	if !hasConfig && !hasLayout {
+		return parent, nil
+	}
+
+	l := *parent
+	if hasConfig {
+		// The theme's stylesheet is added back first, as it may have changed
+		// and a css option replaces the list
+		if l.cfg.Theme != "" {
+			url, _ := themeURL(l.cfg.Theme)
+			l.cfg.CSS = slices.DeleteFunc(slices.Clone(l.cfg.CSS), func(css string) bool { return css == url })
+		}
+		// The renderers option adds to those of the parent
+		parentRenderers := l.cfg.Renderers
+		l.cfg.Renderers = nil
+		if err := loadConfigFile(configFile, &l.cfg); err != nil {
+			return nil, err
+		}
+		renderers, err := normalizeRenderers(l.cfg.Renderers)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", configFile, err)
+		}
+		for ext := range renderers {
+			if !slices.Contains(h.cfg.pageExts(), ext) {
+				return nil, fmt.Errorf("%s: %s files can only be rendered as pages by the top-level config", configFile, ext)
+			}
+		}
+		l.cfg.Renderers = parentRenderers
+		if renderers != nil {
+			l.cfg.Renderers = maps.Clone(parentRenderers)
+			if l.cfg.Renderers == nil {
+				l.cfg.Renderers = make(map[string]string)
+			}
+			maps.Copy(l.cfg.Renderers, renderers)
+		}
+		if l.cfg.AsciidocCommand != parent.cfg.AsciidocCommand || l.cfg.RSTCommand != parent.cfg.RSTCommand || l.cfg.PDFCommand != parent.cfg.PDFCommand {
+			return nil, fmt.Errorf("%s: commands can only be set in the top-level config", configFile)
+		}
+		if err := validateExtensions(l.cfg.Extensions); err != nil {
+			return nil, err
+		}
+		if l.cfg.Theme != "" {
+			url, err := themeURL(l.cfg.Theme)
+			if err != nil {
+				return nil, err
+			}
+			l.cfg.CSS = append([]string{url}, l.cfg.CSS...)
+		}
+	}
+
+	// A _layout.html takes precedence over a template option in the same directory
+	templateFile := ""
+	if hasLayout {
+		templateFile = layoutFile
+	} else if l.cfg.Template != parent.cfg.Template {
+		// Unlike on the command line, the template is relative to the directory
+		templateFile = l.cfg.Template
+		if !filepath.IsAbs(templateFile) {
+			templateFile = filepath.Join(dir, templateFile)
+		}
+	}
+	if templateFile != "" {
+		// Like pages, templates must be in the tree, or anyone writing to it could read any file
+		if _, err := h.containedPath(templateFile); err != nil {
+			return nil, fmt.Errorf("template %s: %w", templateFile, err)
+		}
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return nil, err
+		}
+		l.text = string(data)
+		tmpl, err := h.parseTemplate(l.text)
+		if err != nil {
+			return nil, err
+		}
+		l.tmpl = tmpl
+	}
+
+	if hasConfig {
+		l.md = h.markdownFor(l.cfg)
+	}
+	l.version = h.templateVersion(l.text, l.cfg)
+	return &l, nil
+}
+
+// isOverrideFile reports whether path is a per-directory override file, which
+// isn't served since it may hold settings not meant for visitors.
+func isOverrideFile(path string) bool {
+	name := filepath.Base(path)
+	return name == layoutFileName || name == dirConfigFileName
+}
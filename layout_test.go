@@ -0,0 +1,55 @@
+package mdssr
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLayoutTemplateContained(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(root, "base")
+	secret := filepath.Join(root, "secret.html")
+	writeFiles(t, root, map[string]string{"secret.html": "secret {{ .Content }}"})
+	writeFiles(t, base, map[string]string{
+		"in/" + dirConfigFileName:       "template: _page.html\n",
+		"in/_page.html":                 "in-tree {{ .Content }}",
+		"in/page.md":                    "text\n",
+		"relative/" + dirConfigFileName: "template: ../../secret.html\n",
+		"relative/page.md":              "text\n",
+		"absolute/" + dirConfigFileName: "template: " + secret + "\n",
+		"absolute/page.md":              "text\n",
+		"symlink/page.md":               "text\n",
+	})
+	symlink(t, secret, filepath.Join(base, "symlink", layoutFileName))
+	h, err := newMarkdownHandler(base, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/in/page.md", "in-tree"},
+		{"/relative/page.md", "<!DOCTYPE html>"},
+		{"/absolute/page.md", "<!DOCTYPE html>"},
+		{"/symlink/page.md", "<!DOCTYPE html>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := get(h, tt.path, "")
+			body := w.Body.String()
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s = %d: %s", tt.path, w.Code, body)
+			}
+			if !strings.HasPrefix(body, tt.want) || strings.Contains(body, "secret") {
+				t.Errorf("GET %s isn't rendered with the template starting with %q:\n%s", tt.path, tt.want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package mdssr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// shutdownTimeout is how long requests in flight get to finish on shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// listenAndServe serves handler on cfg.Listen, over HTTPS when a
+// certificate or autocert domains are configured. On SIGINT or SIGTERM it stops
+// accepting connections and returns once the requests in flight are done.
+func listenAndServe(cfg Config, handler http.Handler) error {
+	// Long-lived requests such as the live reload stream end when their
+	// context is done, so cancel it on shutdown
+	baseCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := &http.Server{
+		Addr:        cfg.Listen,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	server.RegisterOnShutdown(cancel)
+
+	useTLS := cfg.tlsEnabled()
+	if useTLS {
+		if err := configureTLS(server, cfg); err != nil {
+			return err
+		}
+	}
+
+	l, err := listen(cfg.Listen)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	ready.Store(true)
+
+	errc := make(chan error, 1)
+	go func() {
+		if useTLS {
+			log.Printf("Serving HTTPS on %s\n", listenerURL("https", l))
+			errc <- server.ServeTLS(l, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			log.Printf("Serving HTTP on %s\n", listenerURL("http", l))
+			errc <- server.Serve(l)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %v, shutting down\n", sig)
+	}
+	ready.Store(false)
+	// A second signal stops right away
+	signal.Reset(os.Interrupt, syscall.SIGTERM)
+
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	return server.Shutdown(ctx)
+}
+
+// listen returns the socket passed by systemd socket activation, if any, and
+// otherwise listens on addr, which is host:port for TCP or unix:/path for a Unix
+// socket. A socket file left behind by a previous run is removed first.
+func listen(addr string) (net.Listener, error) {
+	if l, err := activationListener(); l != nil || err != nil {
+		return l, err
+	}
+	return listenAddr(addr)
+}
+
+// listenAddr listens on addr like listen, without taking the socket passed by systemd.
+func listenAddr(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// activationListener returns the socket systemd passed to this process, or nil
+// if the process wasn't socket activated.
+func activationListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets, expected one", n)
+	}
+
+	// Keep child processes from thinking they were activated too
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFDsStart, "systemd socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// listenerURL returns the address l accepts connections on, in a form that can
+// be opened in a browser where possible.
+func listenerURL(scheme string, l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return "unix:" + l.Addr().String()
+	}
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		return l.Addr().String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
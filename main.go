@@ -1,34 +1,69 @@
-package main
+package mdssr
 
 import (
 	"bytes"
 	"errors"
 	"flag"
+	"fmt"
+	"html"
 	"html/template"
+	"io"
+	"io/fs"
 	"log"
 	"log/slog"
 	"net/http"
 	"net/http/cgi"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/microcosm-cc/bluemonday"
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/parser"
 )
 
 // Template for the rendered HTML pages.
-// It includes placeholders for CSS links, the rendered content, and JS scripts.
+// It includes placeholders for CSS links, head snippets, the color scheme toggle, the search box, the rendered content, the edit link, and JS scripts.
 const htmlTemplate = `<!DOCTYPE html>
-<html>
+<html{{ with .Lang }} lang="{{ . }}"{{ end }}{{ with .ColorScheme }} data-color-scheme="{{ . }}"{{ end }}>
 <head>
     <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="color-scheme" content="{{ or .ColorScheme "light dark" }}">
+    {{- with .ColorSchemeScript }}
+    <script src="{{ . }}"></script>
+    {{- end }}
+    {{- .Meta }}
     {{- range .CSS }}
     <link rel="stylesheet" href="{{ . }}">
     {{- end }}
+    {{- with .Feed }}
+    <link rel="alternate" type="application/atom+xml" href="{{ . }}">
+    {{- end }}
+    {{- range .Alternates }}
+    <link rel="alternate" hreflang="{{ .Lang }}" href="{{ .URL }}">
+    {{- end }}
+    {{- with .Head }}
+    {{ . }}
+    {{- end }}
     <title>{{ .Title }}</title>
 </head>
 <body>
+    {{- if .ColorSchemeScript }}
+    <button type="button" class="color-scheme-toggle" title="Toggle dark mode" hidden>&#9680;</button>
+    {{- end }}
+    {{- with .SearchBox }}
+    {{ . }}
+    {{- end }}
     {{ .Content }}
+    {{- with .EditURL }}
+    <p class="edit-link"><a href="{{ . }}">Edit this page</a></p>
+    {{- end }}
     {{- range .JS }}
     <script src="{{ . }}"></script>
     {{- end }}
@@ -37,48 +72,156 @@ const htmlTemplate = `<!DOCTYPE html>
 
 // PageData holds the data to be injected into the HTML template.
 type PageData struct {
+	// URL is the path the page is served at.
+	URL     string
 	Title   string
 	CSS     []string
 	JS      []string
 	Content template.HTML
+	TOC     template.HTML
+
+	// ColorScheme is the color scheme the page defaults to, light or dark, or
+	// empty to follow the visitor's system. ColorSchemeScript is the URL of
+	// the script applying the scheme picked with the toggle.
+	ColorScheme       string
+	ColorSchemeScript string
+
+	// Page metadata from the front matter. The description defaults to the
+	// start of the first paragraph.
+	Description string
+	Image       string
+	Author      string
+	Date        time.Time
+	// Meta holds the description, Open Graph and Twitter card tags built from the metadata.
+	Meta template.HTML
+	// Head is the raw HTML the front matter adds to the head of the page.
+	Head template.HTML
+
+	// Path, Query and Host are those of the request the page is rendered for,
+	// the path below the mount point. They are empty in pages written by build.
+	Path  string
+	Query url.Values
+	Host  string
+
+	// origin is the scheme and host pages are served from, if known.
+	// It defaults to the site URL.
+	origin string
+	// dir is the directory whose layout the page is rendered with, or empty for
+	// pages not backed by the tree, which use the root layout.
+	dir string
+	// file is the markdown file the page was rendered from, if any.
+	file string
+
+	// Params holds the page's front matter.
+	Params map[string]any
+
+	// Nav is the navigation tree of the site, set when navigation is enabled.
+	Nav []NavItem
+	// EditURL is where the page's source can be edited, set with --edit-base-url or --editable.
+	EditURL string
+	// Commit is the last commit changing the page, set when serving --git-url.
+	Commit *Commit
+	// Breadcrumbs are the directories enclosing the page, from the root down.
+	Breadcrumbs []Breadcrumb
+	// Prev and Next are the neighbouring pages in navigation order, set when
+	// enabled and the page has them.
+	Prev, Next *NavItem
+
+	// SearchBox is the search form, set when search is enabled.
+	SearchBox template.HTML
+
+	// Feed is the URL of the Atom feed, set when the feed is enabled.
+	Feed string
+
+	// Lang is the language of the page and Alternates its translations, including
+	// itself, set when --languages is given.
+	Lang       string
+	Alternates []Alternate
+
+	// Tags and Categories list the terms used across the site with their number
+	// of pages, set when tags are enabled.
+	Tags       []Tag
+	Categories []Tag
+
+	// Recent lists the most recently modified pages, set when --recent is given.
+	Recent []pageSummary
 }
 
-func main() {
+// Main runs the mdssr command with the arguments of the process: it serves the
+// tree given, or with build or check as the first argument, builds a static
+// site from it or checks its links.
+func Main() {
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		runBuild(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		runCheck(os.Args[2:])
+		return
+	}
+
 	// Define command-line flags
-	cssFlag := flag.String("css", "", "Comma-separated list of CSS source URLs to include")
-	jsFlag := flag.String("js", "", "Comma-separated list of JS source URLs to include")
+	loadConfig := configFlags(flag.CommandLine, bindFlags, bindServerFlags)
 
 	// Parse the flags
 	flag.Parse()
 
 	// Ensure that basePath is provided as a positional argument
 	if flag.NArg() < 1 {
-		log.Fatalln("Usage: markdown_renderer [options] <base_path>")
+		log.Fatalln("Usage: markdown_renderer [options] <base_path or .zip/.tar.gz archive>")
 	}
 
-	basePath := flag.Arg(0)
-
-	// Parse CSS and JS sources
-	cssSources := parseSources(*cssFlag)
-	jsSources := parseSources(*jsFlag)
-
-	// Get absolute base path
-	absBasePath, err := filepath.Abs(basePath)
+	// Get the absolute base path, extracting archives like docs.zip
+	absBasePath, cleanup, err := openSource(flag.Arg(0))
 	if err != nil {
-		log.Fatalf("Error getting absolute base path: %v\n", err)
+		log.Fatalf("Error opening base path: %v\n", err)
 	}
+	defer cleanup()
+	fatalf := fatalfFor(cleanup)
 
-	// Create the markdown handler with CSS and JS
-	mdHandler, err := createMarkdownFSHandler(absBasePath, cssSources, jsSources)
+	cfg, err := loadConfig(absBasePath)
 	if err != nil {
-		log.Fatalf("Error creating handler: %v\n", err)
+		fatalf("Error loading config: %v\n", err)
 	}
 
-	// Register the handler
-	http.Handle("/", mdHandler)
+	// Under CGI, the script's URL is where the site is served
+	if os.Getenv("GATEWAY_INTERFACE") != "" && cfg.BaseURL == "" {
+		cfg.BaseURL = strings.TrimSuffix(os.Getenv("SCRIPT_NAME"), "/")
+	}
+
+	// Check out the repository before serving it
+	if cfg.GitURL != "" {
+		if _, err := syncGit(absBasePath, cfg.GitURL, cfg.GitRef); err != nil {
+			fatalf("Error checking out %s: %v\n", cfg.GitURL, err)
+		}
+		if cfg.GitPull > 0 || cfg.GitWebhookSecret != "" {
+			puller := newGitPuller(absBasePath, cfg)
+			if cfg.GitWebhookSecret != "" {
+				http.Handle(gitWebhookPath, puller)
+			}
+		}
+	}
+
+	// Set up live reload before the handler copies the JS sources
+	var lr *liveReload
+	if cfg.Watch {
+		lr = newLiveReload()
+		http.Handle(liveReloadPath, lr)
+		http.Handle(liveReloadScript, lr)
+	}
+
+	// Serve the base path at the root and the other trees at their mount points
+	load := func() (Config, error) { return loadConfig(absBasePath) }
+	for _, m := range append([]Mount{{Path: "/", Root: absBasePath}}, cfg.Mounts...) {
+		if err := serveMount(m, cfg, load, lr); err != nil {
+			fatalf("Error creating handler: %v\n", err)
+		}
+	}
 
 	// Start serving
-	serve()
+	if err := serve(cfg); err != nil {
+		fatalf("%v\n", err)
+	}
 }
 
 // parseSources splits a comma-separated string into a slice of strings, trimming spaces.
@@ -97,119 +240,794 @@ func parseSources(source string) []string {
 	return sources
 }
 
-// createMarkdownFSHandler creates an HTTP handler that serves files from basePath.
-// If a requested file has a .md extension, it renders it as HTML with optional CSS and JS.
-func createMarkdownFSHandler(basePath string, cssSources, jsSources []string) (http.Handler, error) {
-	// Create the file server for static files
-	fs := http.FileServer(http.Dir(basePath))
+// markdownHandler serves files from basePath, rendering markdown files as HTML.
+type markdownHandler struct {
+	basePath string
+	// realBasePath is basePath with symlinks resolved.
+	realBasePath string
+	cfg          Config
+	tmpl         *template.Template
+	tmplText     string
+	partials     *template.Template
+	md           goldmark.Markdown
+	fs           http.Handler
+	assets       http.Handler
+	cache        *renderCache
+	search       *searchIndex
+	nav          *navIndex
+	wiki         *wikiIndex
+	blog         *blogIndex
+	tags         *taxonomyIndex
+	recent       *recentIndex
+	redirects    *redirectIndex
+	shortcodes   *shortcodeSet
+	git          *gitHistory
+	layouts      layoutCache
+	pages        pageIndex
+	pdfs         pdfCache
+	images       *imageResizer
+	streamSlots  chan struct{}
+	sanitize     *bluemonday.Policy
+	auth         *authenticator
+
+	// version identifies the template and options, see etag, and
+	// partialsVersion the partials of --template-dir it includes.
+	version         uint64
+	partialsVersion uint64
+	// treeModTime is when the tree last changed as far as the handler saw, in
+	// nanoseconds since the epoch, starting with when it was created.
+	treeModTime atomic.Int64
+
+	// prefix is the URL path the tree is served at, the base URL followed by the
+	// mount point, without a trailing slash.
+	// Request paths seen by the handler have it stripped.
+	prefix string
+
+	// rewriteLink, if set, maps intra-site markdown links in generated listings.
+	rewriteLink func(string) string
+
+	// middleware wraps the handler returned by New, the first one outermost.
+	middleware []Middleware
+
+	// markdown, given with WithMarkdown, converts pages in place of the pipeline
+	// built from the options, which markdownOptions extend otherwise.
+	markdown        goldmark.Markdown
+	markdownOptions []goldmark.Option
+	// renderers, given with WithRenderer, render the files with their extension.
+	renderers map[string]Renderer
+}
+
+// newMarkdownHandler creates the markdownHandler behind New, with opts applied.
+func newMarkdownHandler(basePath string, cfg Config, opts ...Option) (*markdownHandler, error) {
+	// The theme comes first so the configured stylesheets can override it
+	if cfg.Theme != "" {
+		url, err := themeURL(cfg.Theme)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CSS = append([]string{url}, cfg.CSS...)
+	}
 
 	// Parse the HTML template once
-	tmpl, err := template.New("page").Parse(htmlTemplate)
+	tmplText := htmlTemplate
+	if cfg.Template != "" {
+		data, err := os.ReadFile(cfg.Template)
+		if err != nil {
+			return nil, err
+		}
+		tmplText = string(data)
+	}
+
+	h := &markdownHandler{
+		basePath:     basePath,
+		realBasePath: basePath,
+		cfg:          cfg,
+		// Create the file server for static files
+		fs:     http.FileServer(http.Dir(basePath)),
+		assets: assetsHandler(),
+		prefix: cfg.BaseURL,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	// Options may add page extensions
+	cfg = h.cfg
+	h.treeModTime.Store(time.Now().UnixNano())
+	err := h.loadTemplates(tmplText)
 	if err != nil {
 		return nil, err
 	}
+	if real, err := filepath.EvalSymlinks(basePath); err == nil {
+		h.realBasePath = real
+	}
+	if cfg.CleanURLs {
+		h.rewriteLink = cleanLink
+	}
+	if cfg.WikiLinks {
+		h.wiki = &wikiIndex{stale: true}
+	}
+	if cfg.Blog != "" {
+		h.blog = &blogIndex{stale: true}
+	}
+	if cfg.Tags {
+		h.tags = &taxonomyIndex{}
+	}
+	if cfg.Shortcodes {
+		h.shortcodes = &shortcodeSet{}
+	}
+	if cfg.MaxStreams > 0 {
+		h.streamSlots = make(chan struct{}, cfg.MaxStreams)
+	}
+	if cfg.Recent > 0 {
+		h.recent = &recentIndex{}
+	}
+	if cfg.Redirects {
+		h.redirects = &redirectIndex{}
+	}
+	if cfg.GitURL != "" {
+		h.git = &gitHistory{dir: basePath, stale: true}
+	}
+	h.md = h.markdownFor(cfg)
+	if cfg.Search {
+		indexed := func(rel string, fm FrontMatter) bool { return !cfg.isErrorPage(rel) && cfg.published(fm) }
+		h.search = newSearchIndex(basePath, h.walkMarkdown, indexed, h.canonicalURL)
+	}
+	if cfg.Nav || cfg.PrevNext {
+		h.nav = &navIndex{}
+	}
+	if cfg.Sanitize {
+		h.sanitize = newSanitizer()
+	}
+	if cfg.Cache {
+		h.cache = newRenderCache()
+	}
+	if cfg.ImageResize {
+		if h.images, err = newImageResizer(cfg.ImageCache); err != nil {
+			return nil, err
+		}
+	}
+	if h.auth, err = newAuthenticator(basePath, cfg); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Sanitize the requested path
-		safePath, err := sanitizePath(basePath, filepath.Join(basePath, r.URL.Path))
-		if err != nil {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
+func (h *markdownHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Serve the assets bundled with the binary
+	if strings.HasPrefix(r.URL.Path, assetsPrefix) {
+		h.assets.ServeHTTP(w, r)
+		return
+	}
+
+	// Resized images are checked against the access rules of the image itself
+	if h.images != nil && strings.HasPrefix(r.URL.Path, imagePath) {
+		h.serveImage(w, r)
+		return
+	}
+
+	// The editor is checked against the access rules of the page it edits
+	if h.cfg.Editable && h.auth != nil && strings.HasPrefix(r.URL.Path, editPath) {
+		h.serveEdit(w, r)
+		return
+	}
+
+	if h.auth != nil && !h.auth.allowed(r, r.URL.Path) {
+		challenge(w)
+		return
+	}
+	// Shared caches must not hand the responses of one user to another
+	if h.auth != nil {
+		w.Header().Set("Cache-Control", "private")
+	}
+
+	if h.blog != nil && h.serveBlog(w, r) {
+		return
+	}
+	if h.tags != nil && h.serveTaxonomy(w, r) {
+		return
+	}
+
+	if h.search != nil && r.URL.Path == searchPath {
+		h.serveSearch(w, r)
+		return
+	}
+	if h.recent != nil && r.URL.Path == recentPath {
+		h.serveRecent(w, r)
+		return
+	}
+
+	// A robots.txt in the tree takes precedence over the generated one
+	if h.cfg.Feed && r.URL.Path == feedPath {
+		h.serveFeed(w, r)
+		return
+	}
+	if h.cfg.Sitemap && r.URL.Path == sitemapPath {
+		h.serveSitemap(w, r)
+		return
+	}
+	if h.cfg.Sitemap && r.URL.Path == robotsPath && !fileExists(filepath.Join(h.basePath, "robots.txt")) {
+		h.serveRobots(w, r)
+		return
+	}
+
+	// Sanitize the requested path
+	safePath, err := h.containedPath(filepath.Join(h.basePath, r.URL.Path))
+	if err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Dotfiles and internal files are not served, unless asked for
+	if slices.ContainsFunc(strings.Split(r.URL.Path, "/"), h.cfg.isHidden) {
+		h.notFound(w, r)
+		return
+	}
+
+	// Never serve the config files or access rules, which may hold settings not meant for visitors
+	if safePath == filepath.Join(h.basePath, configFileName) || filepath.Base(safePath) == accessFileName || isOverrideFile(safePath) {
+		h.notFound(w, r)
+		return
+	}
+
+	// Nor the repository of a --git-url checkout
+	if h.git != nil && slices.Contains(strings.Split(r.URL.Path, "/"), ".git") {
+		h.notFound(w, r)
+		return
+	}
+
+	// Check if the path is a directory
+	info, err := os.Stat(safePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		// With clean URLs, /page resolves to page.md
+		if h.cfg.CleanURLs && !strings.HasSuffix(r.URL.Path, "/") {
+			for _, ext := range h.cfg.pageExts() {
+				if _, err := h.containedPath(safePath + ext); err != nil {
+					continue
+				}
+				if mdInfo, err := os.Stat(safePath + ext); err == nil && !mdInfo.IsDir() {
+					h.renderMarkdown(w, r, safePath+ext, mdInfo)
+					return
+				}
+			}
 		}
 
-		// Check if the path is a directory
-		info, err := os.Stat(safePath)
-		if err != nil {
-			// If not found, serve as is (might result in 404)
-			fs.ServeHTTP(w, r)
+		// Moved pages are redirected to where they are now
+		if h.redirects != nil && h.serveRedirect(w, r) {
 			return
 		}
+		h.notFound(w, r)
+		return
+	}
+	if err != nil {
+		// Let the file server report other errors as usual
+		h.fs.ServeHTTP(w, r)
+		return
+	}
 
-		if info.IsDir() {
-			// Redirect directory to include trailing slash and index.md
-			indexPath := strings.TrimSuffix(r.URL.Path, "/") + "/index.md"
-			http.Redirect(w, r, indexPath, http.StatusMovedPermanently)
+	if info.IsDir() {
+		h.serveDirectory(w, r, safePath)
+		return
+	}
+
+	if h.cfg.isMarkdown(info.Name()) {
+		if h.cfg.CleanURLs {
+			// Hide the extension from the address bar
+			h.redirect(w, r, cleanLink(r.URL.Path))
 			return
 		}
 
-		if strings.HasSuffix(info.Name(), ".md") {
-			// Serve the markdown file as rendered HTML
-			renderMarkdown(w, safePath, tmpl, cssSources, jsSources)
-			return
+		// Serve the markdown file as rendered HTML
+		h.renderMarkdown(w, r, safePath, info)
+		return
+	}
+
+	// For non-markdown files, serve them normally
+	h.fs.ServeHTTP(w, r)
+}
+
+// serveDirectory serves a request for the directory dir by rendering its index
+// page, such as index.md or README.md, or with autoindex a directory listing.
+func (h *markdownHandler) serveDirectory(w http.ResponseWriter, r *http.Request, dir string) {
+	indexFile, indexInfo, hasIndex := h.indexFile(dir)
+
+	// Make sure relative links resolve inside the directory
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		h.redirect(w, r, r.URL.Path+"/")
+		return
+	}
+
+	switch {
+	case hasIndex:
+		h.renderMarkdown(w, r, indexFile, indexInfo)
+	case h.cfg.AutoIndex:
+		h.renderDirectory(w, r, dir)
+	default:
+		h.notFound(w, r)
+	}
+}
+
+// indexFile returns the index page of dir and its info, if it has one.
+// Candidates are the index files in order, each with the markdown extensions
+// in order unless it has one.
+func (h *markdownHandler) indexFile(dir string) (string, os.FileInfo, bool) {
+	for _, name := range h.cfg.IndexFiles {
+		candidates := []string{name}
+		if !h.cfg.isMarkdown(name) {
+			candidates = nil
+			for _, ext := range h.cfg.pageExts() {
+				candidates = append(candidates, name+ext)
+			}
 		}
+		for _, candidate := range candidates {
+			file := filepath.Join(dir, candidate)
+			if info, err := os.Stat(file); err == nil && !info.IsDir() {
+				return file, info, true
+			}
+		}
+	}
+	return "", nil, false
+}
 
-		// For non-markdown files, serve them normally
-		fs.ServeHTTP(w, r)
-	}), nil
+// isIndexFile reports whether file is the index page of its directory.
+func (h *markdownHandler) isIndexFile(file string) bool {
+	index, _, ok := h.indexFile(filepath.Dir(file))
+	return ok && index == file
 }
 
-// renderMarkdown reads the markdown file, converts it to HTML, and writes the HTML response.
-func renderMarkdown(w http.ResponseWriter, path string, tmpl *template.Template, cssSources, jsSources []string) {
-	// Read the markdown file
-	mdContent, err := os.ReadFile(path)
+// pageURL returns the URL of the page for the markdown file at rel, a slash-separated
+// path relative to the base path.
+func (h *markdownHandler) pageURL(rel string) string {
+	url := "/" + rel
+	if h.rewriteLink != nil {
+		url = h.rewriteLink(url)
+	}
+	return h.prefix + url
+}
+
+// assetURLs adds the base URL to the root-relative URLs among urls, in place.
+func (h *markdownHandler) assetURLs(urls []string) []string {
+	for i, u := range urls {
+		urls[i] = withBaseURL(h.cfg.BaseURL, u)
+	}
+	return urls
+}
+
+// editURL returns the URL editing file at, the edit base URL followed by the
+// file's path relative to the base path, or without one, the editor of --editable.
+func (h *markdownHandler) editURL(file string) string {
+	rel, err := filepath.Rel(h.basePath, file)
 	if err != nil {
-		http.Error(w, "Unable to read file", http.StatusInternalServerError)
-		log.Printf("Error reading file %s: %v\n", path, err)
+		return ""
+	}
+	if h.cfg.EditBaseURL == "" {
+		return h.prefix + editPath + (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath()
+	}
+	return strings.TrimSuffix(h.cfg.EditBaseURL, "/") + "/" + (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath()
+}
+
+// redirect permanently redirects to the page at urlPath, a path relative to the mount point.
+func (h *markdownHandler) redirect(w http.ResponseWriter, r *http.Request, urlPath string) {
+	http.Redirect(w, r, h.prefix+urlPath, http.StatusMovedPermanently)
+}
+
+// allowed reports whether the request may read the page at url, a path including the mount point.
+// Blog permalinks are checked against the access rules of the post's file.
+func (h *markdownHandler) allowed(r *http.Request, url string) bool {
+	if h.auth == nil {
+		return true
+	}
+	urlPath := strings.TrimPrefix(url, h.prefix)
+	if h.blog != nil {
+		b := h.blogPosts()
+		if i, ok := b.byPermalink[urlPath]; ok {
+			urlPath = "/" + b.posts[i].rel
+		}
+	}
+	return h.auth.allowed(r, urlPath)
+}
+
+// contentChanged is called when files under the base path change.
+// Indexes derived from the whole tree are rebuilt on next use.
+func (h *markdownHandler) contentChanged() {
+	if h.search != nil {
+		h.search.invalidate()
+	}
+	if h.nav != nil {
+		h.nav.invalidate()
+	}
+	if h.blog != nil {
+		h.blog.invalidate()
+	}
+	if h.tags != nil {
+		h.tags.invalidate()
+	}
+	if h.recent != nil {
+		h.recent.invalidate()
+	}
+	if h.redirects != nil {
+		h.redirects.invalidate()
+	}
+	if h.wiki != nil {
+		h.wiki.invalidate()
+	}
+	if h.shortcodes != nil {
+		h.shortcodes.invalidate()
+	}
+	if h.git != nil {
+		h.git.invalidate()
+	}
+	h.layouts.invalidate()
+	h.pages.invalidate()
+	h.treeModTime.Store(time.Now().UnixNano())
+	// Pages may link to the pages that were added or removed, or use the shortcodes that changed
+	if (h.wiki != nil || h.shortcodes != nil) && h.cache != nil {
+		h.cache.reset()
+	}
+}
+
+// renderMarkdown converts the markdown file to HTML and writes the HTML response.
+// Responses carry Last-Modified and ETag headers so unchanged pages can be answered with 304.
+// Pages too large to cache are written out as they are rendered with serveStream.
+func (h *markdownHandler) renderMarkdown(w http.ResponseWriter, r *http.Request, path string, info os.FileInfo) {
+	if h.cfg.Languages != nil {
+		w.Header().Add("Vary", "Accept-Language")
+		path, info = h.negotiateLanguage(r, path, info)
+	}
+	if h.streams(r, info) {
+		h.serveStream(w, r, path, info)
+		return
+	}
+	page, err := h.loadPage(path, info)
+	if err != nil {
+		h.serverError(w, r, "Error rendering markdown")
+		log.Printf("Error rendering markdown %s: %v\n", path, err)
+		return
+	}
+	if !h.cfg.published(page.Meta) {
+		h.notFound(w, r)
+		return
+	}
+
+	if h.serveSourceView(w, r, path, info) {
+		return
+	}
+	if h.cfg.PDFCommand != "" && r.URL.Query().Get("format") == "pdf" {
+		h.servePDF(w, r, path, info, page)
 		return
 	}
+	if h.cfg.JSON {
+		w.Header().Add("Vary", "Accept")
+		if wantsJSON(r) {
+			h.servePageJSON(w, r, path, info, page)
+			return
+		}
+	}
+
+	w.Header().Set("ETag", h.etag(r, path, info, page.Includes...))
+	h.renderPage(w, r, path, page.pageData(), h.lastModified(info))
+}
+
+// loadPage returns the rendered page for path, using the render cache when enabled.
+func (h *markdownHandler) loadPage(path string, info os.FileInfo) (*renderedPage, error) {
+	l := h.layoutFor(filepath.Dir(path))
+	if h.cache != nil {
+		// Pages rendered before the layout of their directory changed are stale
+		if page, ok := h.cache.get(path, info); ok && page.version == l.version {
+			metrics.cacheHits.Add(1)
+			return page, nil
+		}
+		metrics.cacheMisses.Add(1)
+	}
+	start := time.Now()
 
-	// Convert markdown to HTML using Goldmark
+	page, ctx, body, err := h.preparePage(l, path, info)
+	if err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
-	if err := goldmark.Convert(mdContent, &buf); err != nil {
-		http.Error(w, "Error rendering markdown", http.StatusInternalServerError)
-		log.Printf("Error converting markdown %s: %v\n", path, err)
+	if err := body(&buf); err != nil {
+		return nil, err
+	}
+
+	content := buf.Bytes()
+	if h.sanitize != nil {
+		content = h.sanitize.SanitizeBytes(content)
+	}
+	if h.shortcodes != nil {
+		content = h.expandShortcodes(content, ctx)
+	}
+	if h.cfg.Images {
+		var images []includedFile
+		content, images = h.rewriteImages(content, path)
+		page.Includes = append(page.Includes, images...)
+	}
+	if page.Title == "" {
+		page.Title = extractTitle(content)
+	}
+	page.Content = template.HTML(content)
+
+	// Large pages are rendered again rather than held on to
+	if h.cache != nil && info.Size() <= streamThreshold {
+		h.cache.put(path, page)
+	}
+	metrics.observeRender(time.Since(start))
+	return page, nil
+}
+
+// preparePage reads the page at path, in the directory of layout l, and parses
+// it as far as its renderer allows, returning the page without its content, the
+// parser context and a function writing the content. Files larger than
+// --max-page-size are refused.
+func (h *markdownHandler) preparePage(l *layout, path string, info os.FileInfo) (*renderedPage, parser.Context, func(io.Writer) error, error) {
+	if h.cfg.MaxPageSize > 0 && info.Size() > h.cfg.MaxPageSize {
+		return nil, nil, nil, fmt.Errorf("%d bytes exceeds --max-page-size of %d", info.Size(), h.cfg.MaxPageSize)
+	}
+
+	// Read the markdown file
+	fm, mdContent, err := readMarkdown(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var includes []includedFile
+	if h.cfg.Include {
+		mdContent, includes = h.expandIncludes(path, mdContent)
+	}
+
+	// Parse the page with the renderer of its extension
+	ctx := parser.NewContext(parser.WithIDs(newSlugIDs()))
+	if dir, ok := h.postDir(path); ok {
+		ctx.Set(pageDirKey, dir)
+	}
+	body := prepareBody(h.rendererFor(l, path), mdContent, ctx)
+
+	// The front matter can add to the assets, but not scripts to untrusted pages
+	assets := assetsFromContext(ctx)
+	assets.CSS = appendMissing(assets.CSS, fm.CSS...)
+	var head template.HTML
+	if h.sanitize == nil {
+		assets.JS = appendMissing(assets.JS, fm.JS...)
+		head = template.HTML(fm.Head)
+	}
+
+	title, _ := pageTitle(fm, mdContent)
+	if title == "" {
+		title = headingFromContext(ctx)
+	}
+	meta := fm
+	meta.Params = nil
+	if meta.Description == "" {
+		meta.Description = summaryFromContext(ctx)
+	}
+
+	page := &renderedPage{
+		Title:    title,
+		Meta:     meta,
+		Params:   fm.Params,
+		TOC:      tocFromContext(ctx),
+		Head:     head,
+		Assets:   assets,
+		ModTime:  info.ModTime(),
+		Size:     info.Size(),
+		Includes: includes,
+		dir:      filepath.Dir(path),
+		file:     path,
+		version:  l.version,
+	}
+	return page, ctx, body, nil
+}
+
+// renderPage executes the page template with data and writes the response.
+// A zero modTime disables Last-Modified handling.
+func (h *markdownHandler) renderPage(w http.ResponseWriter, r *http.Request, path string, data PageData, modTime time.Time) {
+	page, err := h.executeFor(r, data)
+	if err != nil {
+		h.serverError(w, r, "Error rendering page")
+		log.Printf("Error executing template for %s: %v\n", path, err)
 		return
 	}
 
+	// ServeContent takes care of conditional requests
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	h.setSecurityHeaders(w, data)
+	http.ServeContent(w, r, path, modTime, bytes.NewReader(page))
+}
+
+// executeFor executes the page template with data for the page requested by r.
+func (h *markdownHandler) executeFor(r *http.Request, data PageData) ([]byte, error) {
+	data.URL = h.prefix + r.URL.Path
+	data.Path, data.Query, data.Host = r.URL.Path, r.URL.Query(), r.Host
+	data.origin = h.siteOrigin(r)
+	if h.nav != nil && h.auth != nil {
+		// Only show the pages this visitor may read
+		data.Nav = filterNav(h.navFor(data.URL), func(url string) bool { return h.allowed(r, url) })
+	}
+	if h.tags != nil && h.auth != nil {
+		allowed := func(url string) bool { return h.allowed(r, url) }
+		data.Tags, data.Categories = h.tagCloud(0, allowed), h.tagCloud(1, allowed)
+	}
+	if h.recent != nil && h.auth != nil && data.Recent == nil {
+		data.Recent = h.recentPages(func(url string) bool { return h.allowed(r, url) })
+	}
+	return h.executePage(data)
+}
+
+// executePage executes the page template with data, using the layout of the
+// directory the page is in. The configured CSS and JS are placed before any
+// page-specific assets in data.
+func (h *markdownHandler) executePage(data PageData) ([]byte, error) {
+	l := h.rootLayout()
+	if data.dir != "" {
+		l = h.layoutFor(data.dir)
+	}
+
 	// Prepare the data for the template
-	data := PageData{
-		Title:   extractTitle(mdContent),
-		CSS:     cssSources,
-		JS:      jsSources,
-		Content: template.HTML(buf.String()),
+	data.CSS = h.assetURLs(appendMissing(slices.Clone(l.cfg.CSS), data.CSS...))
+	data.JS = h.assetURLs(appendMissing(slices.Clone(l.cfg.JS), data.JS...))
+	if l.cfg.ColorScheme != "auto" {
+		data.ColorScheme = l.cfg.ColorScheme
+	}
+	data.ColorSchemeScript = withBaseURL(h.cfg.BaseURL, colorSchemeScript)
+	if h.search != nil && data.SearchBox == "" {
+		data.SearchBox = h.searchBox("")
+	}
+	if h.nav != nil && data.Nav == nil {
+		data.Nav = h.navFor(data.URL)
+	}
+	if h.cfg.PrevNext && data.Prev == nil && data.Next == nil {
+		data.Prev, data.Next = neighbours(append(h.homeNav(), data.Nav...), data.URL)
+	}
+	if !h.cfg.Nav {
+		data.Nav = nil
 	}
+	if data.Breadcrumbs == nil {
+		data.Breadcrumbs = h.breadcrumbs(data.URL)
+	}
+	if (h.cfg.EditBaseURL != "" || h.cfg.Editable) && data.file != "" && data.EditURL == "" {
+		data.EditURL = h.editURL(data.file)
+	}
+	if h.git != nil && data.file != "" && data.Commit == nil {
+		if commit, ok := h.git.lastCommit(data.file); ok {
+			data.Commit = &commit
+		}
+	}
+	if h.tags != nil && data.Tags == nil {
+		all := func(string) bool { return true }
+		data.Tags, data.Categories = h.tagCloud(0, all), h.tagCloud(1, all)
+	}
+	if h.recent != nil && data.Recent == nil {
+		data.Recent = h.recentPages(func(string) bool { return true })
+	}
+	if h.cfg.Feed {
+		data.Feed = h.prefix + feedPath
+	}
+	if data.origin == "" {
+		data.origin = h.siteOrigin(nil)
+	}
+	if h.cfg.Languages != nil && data.file != "" && data.Lang == "" {
+		data.Alternates, data.Lang = h.alternates(data.file)
+		for i := range data.Alternates {
+			data.Alternates[i].URL = data.origin + data.Alternates[i].URL
+		}
+	}
+	data.Meta = metaTags(data, data.origin)
 
 	// Execute the template
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := tmpl.Execute(w, data); err != nil {
-		http.Error(w, "Error rendering page", http.StatusInternalServerError)
-		log.Printf("Error executing template for %s: %v\n", path, err)
-		return
+	var buf bytes.Buffer
+	if err := l.tmpl.Execute(&buf, data); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
 }
 
-// extractTitle extracts the first markdown header as the page title.
+// h1Pattern matches the level-one headings of a rendered page, and tagPattern
+// the tags within them.
+var (
+	h1Pattern  = regexp.MustCompile(`(?s)<h1\b[^>]*>(.*?)</h1>`)
+	tagPattern = regexp.MustCompile(`<[^>]*>`)
+)
+
+// extractTitle extracts the first h1 of the rendered page content as the title
+// of a page without a markdown header.
 // If no header is found, it defaults to "Document".
-func extractTitle(md []byte) string {
+func extractTitle(content []byte) string {
+	if m := h1Pattern.FindSubmatch(content); m != nil {
+		if title := strings.TrimSpace(html.UnescapeString(tagPattern.ReplaceAllString(string(m[1]), ""))); title != "" {
+			return title
+		}
+	}
+	return "Document"
+}
+
+// findTitle returns the text of the first level-one markdown header, if any.
+func findTitle(md []byte) (string, bool) {
 	lines := bytes.Split(md, []byte("\n"))
 	for _, line := range lines {
 		line = bytes.TrimSpace(line)
 		if bytes.HasPrefix(line, []byte("# ")) {
-			return string(bytes.TrimPrefix(line, []byte("# ")))
+			return string(bytes.TrimPrefix(line, []byte("# "))), true
 		}
 	}
-	return "Document"
+	return "", false
+}
+
+// fileExists reports whether path exists and is a regular file.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
 }
 
 // sanitizePath ensures that the requested path is within the base directory to prevent directory traversal.
+// It only looks at the path itself; see containedPath for symlinks.
 func sanitizePath(absBasePath string, path string) (string, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return "", err
 	}
-	if !strings.HasPrefix(absPath, absBasePath) {
+	if !isWithin(absBasePath, absPath) {
 		return "", errors.New("path outside allowed directory")
 	}
 	return absPath, nil
 }
 
-// serve attempts to serve via CGI first and falls back to an HTTP server if CGI fails.
-func serve() {
-	err := cgi.Serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// isWithin reports whether path is dir or a path under it. Both must be absolute and clean.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// containedPath is like sanitizePath for the base path of h, and also rejects
+// paths that are symlinks, or in symlinked directories, resolving outside the
+// base path unless --follow-symlinks is given. Paths that don't exist pass.
+func (h *markdownHandler) containedPath(path string) (string, error) {
+	absPath, err := sanitizePath(h.basePath, path)
+	if err != nil || h.cfg.FollowSymlinks {
+		return absPath, err
+	}
+	resolved, err := filepath.EvalSymlinks(absPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return absPath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !isWithin(h.realBasePath, resolved) {
+		return "", errors.New("symlink outside allowed directory")
+	}
+	return absPath, nil
+}
+
+// isInternal reports whether the file or directory name is left out of listings
+// and indexes: dotfiles and files starting with _, like _layout.html.
+func isInternal(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// isHidden reports whether the file or directory name is kept from visitors:
+// internal files other than .well-known, unless --hidden is given.
+func (c Config) isHidden(name string) bool {
+	return !c.Hidden && isInternal(name) && name != ".well-known"
+}
+
+// serve attempts to serve via CGI first and falls back to an HTTP server on cfg.Listen if CGI fails.
+// With --fcgi, it serves FastCGI instead.
+func serve(cfg Config) error {
+	handler, err := serverHandler(cfg)
+	if err != nil {
+		return err
+	}
+	if err := registerMonitoring(cfg); err != nil {
+		return err
+	}
+	if cfg.FastCGI != "" {
+		return serveFastCGI(cfg.FastCGI, handler)
+	}
+
+	err = cgi.Serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ready.Store(true)
 		pathInfo := os.Getenv("PATH_INFO")
 		// Redirect to original path + "/" if there is no sub path
 		if pathInfo == "" {
-			redirectPath := os.Getenv("SCRIPT_NAME") + "/index.md"
+			redirectPath := os.Getenv("SCRIPT_NAME") + "/"
 			if r.URL.RawQuery != "" {
 				redirectPath += "?" + r.URL.RawQuery
 			}
@@ -218,13 +1036,41 @@ func serve() {
 			return
 		}
 		r.URL.Path = pathInfo
-		http.DefaultServeMux.ServeHTTP(w, r)
+		handler.ServeHTTP(w, r)
 	}))
 
 	if err != nil {
 		slog.Warn("Unable to serve via CGI, falling back to HTTP server", "error", err)
-		port := ":8000"
-		log.Printf("Serving HTTP on http://localhost%s\n", port)
-		log.Fatal(http.ListenAndServe(port, nil))
+		return listenAndServe(cfg, handler)
 	}
+	return nil
+}
+
+// walkMarkdown calls fn for every markdown file under root that isn't hidden,
+// with its path and its slash-separated path relative to root. Files that
+// containedPath rejects, like symlinks pointing outside the base path, are
+// left out.
+func (h *markdownHandler) walkMarkdown(root string, fn func(path, rel string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && isInternal(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !h.cfg.isMarkdown(d.Name()) {
+			return nil
+		}
+		if _, err := h.containedPath(path); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return fn(path, filepath.ToSlash(rel), d)
+	})
 }
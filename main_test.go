@@ -0,0 +1,197 @@
+package mdssr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates the files of tree under dir, each with its name as content.
+func writeTree(t *testing.T, dir string, tree ...string) {
+	t.Helper()
+	for _, name := range tree {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeFiles creates the files under dir, mapping slash-separated names to content.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// get serves a GET request for target from h, as user if not empty.
+func get(h http.Handler, target, user string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if user != "" {
+		r.SetBasicAuth(user, "secret")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// symlink creates a symlink at link pointing to target.
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+}
+
+// traversalTree returns the base path of a tree with symlinks pointing in and
+// out of it, next to a sibling directory sharing its name as a prefix.
+func traversalTree(t *testing.T) string {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(root, "base")
+	writeTree(t, root, "outside.txt", "outside.md", "outdir/secret.txt", "base-evil/secret.txt")
+	writeTree(t, base, "index.md", "in.txt", "sub/page.md", ".secret", "_draft.md", "sub/.access", ".well-known/security.txt", configFileName)
+	symlink(t, "in.txt", filepath.Join(base, "link-in.txt"))
+	symlink(t, filepath.Join(root, "outside.txt"), filepath.Join(base, "link-out.txt"))
+	symlink(t, filepath.Join(root, "outside.md"), filepath.Join(base, "sub", "link-out.md"))
+	symlink(t, filepath.Join(root, "outdir"), filepath.Join(base, "dir-out"))
+	symlink(t, "sub", filepath.Join(base, "dir-in"))
+	return base
+}
+
+func TestSanitizePath(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "base")
+	tests := []struct {
+		name string
+		path string
+		ok   bool
+	}{
+		{"base itself", base, true},
+		{"file", filepath.Join(base, "page.md"), true},
+		{"nested dot dot staying inside", base + "/sub/../page.md", true},
+		{"parent", base + "/..", false},
+		{"dot dot out", base + "/../outside.txt", false},
+		{"dot dot out of a subdirectory", base + "/sub/../../outside.txt", false},
+		{"sibling sharing the prefix", base + "-evil/secret.txt", false},
+		{"sibling reached with dot dot", base + "/../base-evil/secret.txt", false},
+		{"root", "/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sanitizePath(base, tt.path)
+			if (err == nil) != tt.ok {
+				t.Errorf("sanitizePath(%q) error = %v, want ok %v", tt.path, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestContainedPath(t *testing.T) {
+	base := traversalTree(t)
+	tests := []struct {
+		name   string
+		path   string
+		ok     bool
+		follow bool
+	}{
+		{"file", "in.txt", true, true},
+		{"missing file", "missing.md", true, true},
+		{"dot dot out", "../outside.txt", false, false},
+		{"sibling sharing the prefix", "../base-evil/secret.txt", false, false},
+		{"symlink inside", "link-in.txt", true, true},
+		{"symlinked directory inside", "dir-in/page.md", true, true},
+		{"symlink out", "link-out.txt", false, true},
+		{"symlinked directory out", "dir-out", false, true},
+		{"file in symlinked directory out", "dir-out/secret.txt", false, true},
+	}
+	for _, follow := range []bool{false, true} {
+		cfg := DefaultConfig()
+		cfg.FollowSymlinks = follow
+		h, err := newMarkdownHandler(base, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			want := tt.ok || follow && tt.follow
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := h.containedPath(filepath.Join(base, filepath.FromSlash(tt.path)))
+				if (err == nil) != want {
+					t.Errorf("containedPath(%q) with follow-symlinks %v error = %v, want ok %v", tt.path, follow, err, want)
+				}
+			})
+		}
+	}
+}
+
+func TestServeTraversal(t *testing.T) {
+	base := traversalTree(t)
+	h, err := newMarkdownHandler(base, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/in.txt", http.StatusOK},
+		{"/link-in.txt", http.StatusOK},
+		{"/../outside.txt", http.StatusForbidden},
+		{"/sub/../../outside.txt", http.StatusForbidden},
+		{"/../base-evil/secret.txt", http.StatusForbidden},
+		{"/link-out.txt", http.StatusForbidden},
+		{"/dir-out/secret.txt", http.StatusForbidden},
+		{"/.secret", http.StatusNotFound},
+		{"/_draft.md", http.StatusNotFound},
+		{"/sub/.access", http.StatusNotFound},
+		{"/" + configFileName, http.StatusNotFound},
+		{"/.well-known/security.txt", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.URL.Path = tt.path
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestIsHidden(t *testing.T) {
+	tests := []struct {
+		name   string
+		hidden bool
+		want   bool
+	}{
+		{"page.md", false, false},
+		{".git", false, true},
+		{".access", false, true},
+		{"_layout.html", false, true},
+		{".well-known", false, false},
+		{".git", true, false},
+		{"_layout.html", true, false},
+	}
+	for _, tt := range tests {
+		cfg := Config{Hidden: tt.hidden}
+		if got := cfg.isHidden(tt.name); got != tt.want {
+			t.Errorf("isHidden(%q) with --hidden %v = %v, want %v", tt.name, tt.hidden, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package mdssr
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	emoji "github.com/yuin/goldmark-emoji"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// optionalExtensions are the goldmark extensions --extensions enables by name.
+var optionalExtensions = map[string]goldmark.Extender{
+	"deflist":       extension.DefinitionList,
+	"footnotes":     extension.Footnote,
+	"linkify":       extension.Linkify,
+	"strikethrough": extension.Strikethrough,
+	"tables":        extension.Table,
+	"tasklists":     extension.TaskList,
+	"typographer":   extension.Typographer,
+}
+
+// optionalExtensionNames returns the names of the optional extensions in order.
+func optionalExtensionNames() []string {
+	return slices.Sorted(maps.Keys(optionalExtensions))
+}
+
+// validateExtensions checks that every name is one of the optional extensions.
+func validateExtensions(names []string) error {
+	for _, name := range names {
+		if _, ok := optionalExtensions[name]; !ok {
+			return fmt.Errorf("unknown markdown extension %q, available extensions: %s", name, strings.Join(optionalExtensionNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// markdownFor returns the goldmark pipeline converting pages with cfg: the one
+// given with WithMarkdown, or else the one built by newMarkdown, extended with
+// the options given with WithMarkdownOptions.
+func (h *markdownHandler) markdownFor(cfg Config) goldmark.Markdown {
+	if h.markdown != nil {
+		return h.markdown
+	}
+	return newMarkdown(cfg, h.rewriteLink, h.resolveWikiLink, h.markdownOptions...)
+}
+
+// newMarkdown builds the goldmark pipeline used to convert pages.
+// If rewriteLink is non-nil, it is applied to the path of every intra-site markdown link.
+// resolveWikiLink looks up the targets of wiki links when they are enabled.
+// The extra options are applied last.
+func newMarkdown(cfg Config, rewriteLink func(string) string, resolveWikiLink func(string) (string, bool), extra ...goldmark.Option) goldmark.Markdown {
+	opts := []parser.Option{parser.WithASTTransformers(
+		// Ahead of the link transformer, which only rewrites root-relative links as they are
+		util.Prioritized(postLinkTransformer{}, 90),
+		util.Prioritized(summaryTransformer{}, 500),
+	)}
+	if cfg.BaseURL != "" {
+		// Ahead of the post link transformer, whose links already have the base URL
+		opts = append(opts, parser.WithASTTransformers(util.Prioritized(baseURLTransformer{base: cfg.BaseURL}, 80)))
+	}
+	if rewriteLink != nil {
+		opts = append(opts, parser.WithASTTransformers(
+			util.Prioritized(&linkTransformer{rewrite: rewriteLink, isMarkdown: cfg.isMarkdown}, 100),
+		))
+	}
+
+	extensions := []goldmark.Extender{tocExtension{}}
+	for _, name := range cfg.Extensions {
+		if ext, ok := optionalExtensions[name]; ok {
+			extensions = append(extensions, ext)
+		}
+	}
+	if cfg.Mermaid {
+		extensions = append(extensions, &mermaidExtension{script: cfg.MermaidJS})
+	}
+	if cfg.Math {
+		extensions = append(extensions, mathExtension{})
+	}
+	if cfg.Emoji {
+		extensions = append(extensions, emoji.New(emoji.WithRenderingMethod(emoji.Unicode)))
+	}
+	if cfg.HeadingAnchors {
+		extensions = append(extensions, anchorExtension{})
+	}
+	if cfg.Admonitions {
+		extensions = append(extensions, admonitionExtension{})
+	}
+	if cfg.WikiLinks {
+		extensions = append(extensions, wikiLinkExtension{resolve: resolveWikiLink})
+	}
+	if cfg.Shortcodes {
+		extensions = append(extensions, shortcodeExtension{})
+	}
+
+	var rendererOpts []renderer.Option
+	if cfg.HTML {
+		rendererOpts = append(rendererOpts, html.WithUnsafe())
+	}
+
+	return goldmark.New(append([]goldmark.Option{
+		goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(opts...),
+		goldmark.WithRendererOptions(rendererOpts...),
+	}, extra...)...)
+}
+
+// pageAssetsKey stores the pageAssets requested while parsing a page.
+var pageAssetsKey = parser.NewContextKey()
+
+// pageAssets are stylesheets and scripts a single page needs on top of the configured ones.
+type pageAssets struct {
+	CSS []string
+	JS  []string
+}
+
+// addPageAssets records that the page being parsed with pc needs the given CSS and JS.
+func addPageAssets(pc parser.Context, css, js []string) {
+	assets := assetsFromContext(pc)
+	assets.CSS = appendMissing(assets.CSS, css...)
+	assets.JS = appendMissing(assets.JS, js...)
+	pc.Set(pageAssetsKey, assets)
+}
+
+// assetsFromContext returns the page assets requested while parsing with pc.
+func assetsFromContext(pc parser.Context) pageAssets {
+	assets, _ := pc.Get(pageAssetsKey).(pageAssets)
+	return assets
+}
+
+// appendMissing appends the values that are not already in list.
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// linkTransformer rewrites the destination of links that point to markdown files within the site.
+type linkTransformer struct {
+	rewrite    func(string) string
+	isMarkdown func(string) bool
+}
+
+func (t *linkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if link, ok := n.(*ast.Link); ok && entering {
+			link.Destination = []byte(rewriteMarkdownLink(string(link.Destination), t.isMarkdown, t.rewrite))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// rewriteMarkdownLink applies rewrite to the path of dest if dest is a relative or
+// root-relative link to a markdown file. Query and fragment are preserved.
+func rewriteMarkdownLink(dest string, isMarkdown func(string) bool, rewrite func(string) string) string {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || !isMarkdown(u.Path) {
+		return dest
+	}
+	u.Path = rewrite(u.Path)
+	return u.String()
+}
+
+// baseURLTransformer adds the base URL to root-relative link and image destinations.
+type baseURLTransformer struct {
+	base string
+}
+
+func (t baseURLTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = []byte(withBaseURL(t.base, string(n.Destination)))
+		case *ast.Image:
+			n.Destination = []byte(withBaseURL(t.base, string(n.Destination)))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// withBaseURL adds base to link if it is a root-relative path.
+func withBaseURL(base, link string) string {
+	if !strings.HasPrefix(link, "/") || strings.HasPrefix(link, "//") {
+		return link
+	}
+	return base + link
+}
+
+// cleanLink maps a markdown file path to its extension-less URL.
+// Index pages map to their directory.
+func cleanLink(p string) string {
+	p = strings.TrimSuffix(p, path.Ext(p))
+	if p == "index" {
+		return "./"
+	}
+	if strings.HasSuffix(p, "/index") {
+		return strings.TrimSuffix(p, "index")
+	}
+	return p
+}
+
+// htmlLink maps a markdown file path to the path of its exported HTML file.
+func htmlLink(p string) string {
+	return strings.TrimSuffix(p, path.Ext(p)) + ".html"
+}
+
+// pageExts returns the extensions of the files rendered as pages: the markdown
+// extensions, then those given other renderers with --renderers.
+func (c Config) pageExts() []string {
+	exts := slices.Clone(c.MarkdownExts)
+	for _, ext := range slices.Sorted(maps.Keys(c.Renderers)) {
+		if !slices.Contains(exts, ext) {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
+// markdownExt returns the extension of name if it is one of the page
+// extensions, which are matched regardless of case, or else "".
+func (c Config) markdownExt(name string) string {
+	ext := path.Ext(name)
+	for _, e := range c.pageExts() {
+		if strings.EqualFold(ext, e) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// isMarkdown reports whether name has one of the markdown extensions.
+func (c Config) isMarkdown(name string) bool {
+	return c.markdownExt(name) != ""
+}
+
+// pageName returns name without its markdown extension.
+func (c Config) pageName(name string) string {
+	return strings.TrimSuffix(name, c.markdownExt(name))
+}
+
+// isErrorPage reports whether the markdown file at rel is an error page like 404.md.
+func (c Config) isErrorPage(rel string) bool {
+	name := c.pageName(path.Base(rel))
+	return name == "404" || name == "500"
+}
@@ -0,0 +1,212 @@
+package mdssr
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KaTeX assets included on pages that contain math.
+const katexBaseURL = "https://cdn.jsdelivr.net/npm/katex@0.16.11/dist/"
+
+var (
+	mathCSS = []string{katexBaseURL + "katex.min.css"}
+	mathJS  = []string{katexBaseURL + "katex.min.js", assetsPrefix + "math.js"}
+)
+
+// Node kinds of math in the AST.
+var (
+	KindMathInline = ast.NewNodeKind("MathInline")
+	KindMathBlock  = ast.NewNodeKind("MathBlock")
+)
+
+// mathInline is $...$ (or $$...$$ within a paragraph) math.
+type mathInline struct {
+	ast.BaseInline
+	Display bool
+	Value   text.Segment
+}
+
+func (n *mathInline) Kind() ast.NodeKind {
+	return KindMathInline
+}
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mathBlock is a $$ delimited display math block.
+type mathBlock struct {
+	ast.BaseBlock
+	closed bool
+}
+
+func (n *mathBlock) Kind() ast.NodeKind {
+	return KindMathBlock
+}
+
+func (n *mathBlock) IsRaw() bool {
+	return true
+}
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mathExtension passes $...$ and $$...$$ math through to the page untouched by
+// markdown processing, wrapped in elements with the "math" class that the bundled
+// script renders with KaTeX.
+type mathExtension struct{}
+
+func (mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 150)),
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(mathRenderer{}, 150)))
+}
+
+// mathBlockParser parses display math delimited by lines starting with $$.
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+
+	node := &mathBlock{}
+	start := pos + 2
+	rest := util.TrimRightSpace(line[start:])
+	if len(rest) >= 2 && bytes.HasSuffix(rest, []byte("$$")) {
+		// Single line $$...$$
+		node.Lines().Append(text.NewSegment(segment.Start+start, segment.Start+start+len(rest)-2))
+		node.closed = true
+	} else if !util.IsBlank(rest) {
+		node.Lines().Append(text.NewSegment(segment.Start+start, segment.Stop))
+	}
+	reader.Advance(segment.Len() - 1)
+	addPageAssets(pc, mathCSS, mathJS)
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	if node.(*mathBlock).closed {
+		return parser.Close
+	}
+
+	line, segment := reader.PeekLine()
+	if line == nil {
+		return parser.Close
+	}
+	trimmed := util.TrimRightSpace(util.TrimLeftSpace(line))
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		if content := trimmed[:len(trimmed)-2]; len(content) > 0 {
+			start := segment.Start + bytes.Index(line, content)
+			node.Lines().Append(text.NewSegment(start, start+len(content)))
+		}
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+
+	node.Lines().Append(segment)
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// mathInlineParser parses $...$ and $$...$$ within a single line.
+// Like pandoc, the opening $ must not be followed by a space and the closing $
+// must not be preceded by a space or followed by a digit, so prices are left alone.
+// A $ that is part of $$ never closes inline math.
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+
+	if bytes.HasPrefix(line, []byte("$$")) {
+		end := bytes.Index(line[2:], []byte("$$"))
+		if end <= 0 {
+			return nil
+		}
+		block.Advance(end + 4)
+		addPageAssets(pc, mathCSS, mathJS)
+		return &mathInline{Display: true, Value: text.NewSegment(segment.Start+2, segment.Start+2+end)}
+	}
+
+	if len(line) < 3 || util.IsSpace(line[1]) {
+		return nil
+	}
+	for i := 2; i < len(line); i++ {
+		if line[i] != '$' || line[i-1] == '\\' || line[i-1] == '$' {
+			continue
+		}
+		if i+1 < len(line) && (line[i+1] == '$' || '0' <= line[i+1] && line[i+1] <= '9') {
+			continue
+		}
+		if util.IsSpace(line[i-1]) {
+			continue
+		}
+		block.Advance(i + 1)
+		addPageAssets(pc, mathCSS, mathJS)
+		return &mathInline{Value: text.NewSegment(segment.Start+1, segment.Start+i)}
+	}
+	return nil
+}
+
+// mathRenderer writes math nodes as HTML-escaped TeX.
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMathInline, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		math := n.(*mathInline)
+		class := "math inline"
+		if math.Display {
+			class = "math display"
+		}
+		_, _ = w.WriteString(`<span class="` + class + `">`)
+		_, _ = w.Write(util.EscapeHTML(math.Value.Value(source)))
+		_, _ = w.WriteString("</span>")
+		return ast.WalkSkipChildren, nil
+	})
+
+	reg.Register(KindMathBlock, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			_, _ = w.WriteString("</div>\n")
+			return ast.WalkContinue, nil
+		}
+		_, _ = w.WriteString(`<div class="math display">`)
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			line := lines.At(i)
+			_, _ = w.Write(util.EscapeHTML(line.Value(source)))
+		}
+		return ast.WalkContinue, nil
+	})
+}
@@ -0,0 +1,103 @@
+// Package mdssr serves a tree of markdown files as HTML pages, rendered as
+// they are requested. New returns the handler to embed in a server of your
+// own, NewFS one for an fs.FS like a go:embed tree, and Main runs the mdssr
+// command.
+package mdssr
+
+import (
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/yuin/goldmark"
+)
+
+// Option customizes the handler returned by New.
+type Option func(*markdownHandler)
+
+// WithMiddleware wraps the handler in middleware, the first one outermost, so
+// requests can be logged, authenticated or traced around rendering.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(h *markdownHandler) {
+		h.middleware = append(h.middleware, middleware...)
+	}
+}
+
+// WithMarkdown converts pages with md in every directory, in place of the
+// pipeline built from the options. Features relying on the extensions of that
+// pipeline, like the table of contents, heading anchors, wiki links and the
+// rewriting of links to pages, are then up to md.
+func WithMarkdown(md goldmark.Markdown) Option {
+	return func(h *markdownHandler) {
+		h.markdown = md
+	}
+}
+
+// WithMarkdownOptions adds opts to the pipeline built from the options in
+// every directory, such as goldmark.WithExtensions, or goldmark.WithParserOptions
+// and goldmark.WithRendererOptions with AST transformers and node renderers.
+func WithMarkdownOptions(opts ...goldmark.Option) Option {
+	return func(h *markdownHandler) {
+		h.markdownOptions = append(h.markdownOptions, opts...)
+	}
+}
+
+// WithRenderer renders the files with extension ext as pages with r in every
+// directory, in place of the renderer the options give them, if any.
+func WithRenderer(ext string, r Renderer) Option {
+	return func(h *markdownHandler) {
+		ext = normalizeExt(ext)
+		if h.renderers == nil {
+			h.renderers = make(map[string]Renderer)
+		}
+		h.renderers[ext] = r
+		// Like the extensions of the renderers option, ext is rendered as pages
+		if _, ok := h.cfg.Renderers[ext]; !ok && !slices.Contains(h.cfg.MarkdownExts, ext) {
+			h.cfg.Renderers = maps.Clone(h.cfg.Renderers)
+			if h.cfg.Renderers == nil {
+				h.cfg.Renderers = make(map[string]string)
+			}
+			h.cfg.Renderers[ext] = "markdown"
+		}
+	}
+}
+
+// New returns a handler serving the tree at basePath with the options in cfg,
+// which usually starts out as DefaultConfig. Markdown files are rendered as
+// pages and other files served as they are. Unlike the command, the handler
+// doesn't watch the tree, so the indexes of features like navigation and
+// search are built once.
+func New(basePath string, cfg Config, opts ...Option) (http.Handler, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	absBasePath, err := filepath.Abs(basePath)
+	if err != nil {
+		return nil, err
+	}
+	h, err := newMarkdownHandler(absBasePath, cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return chain(h, h.middleware...), nil
+}
+
+// NewFS is like New for the tree in fsys, such as content embedded with
+// go:embed, whose directory is picked with fs.Sub. The handler works on files
+// on disk, so fsys is copied to a temporary directory, which lives as long as
+// the process.
+func NewFS(fsys fs.FS, cfg Config, opts ...Option) (http.Handler, error) {
+	dir, err := extractFS(fsys)
+	if err != nil {
+		return nil, err
+	}
+	h, err := New(dir, cfg, opts...)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return h, nil
+}
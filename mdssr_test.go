@@ -0,0 +1,179 @@
+package mdssr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// emphasizer turns every paragraph into emphasis, to tell pages it converted.
+type emphasizer struct{}
+
+func (emphasizer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		if p, ok := n.(*ast.Paragraph); ok {
+			em := ast.NewEmphasis(1)
+			for c := p.FirstChild(); c != nil; c = p.FirstChild() {
+				em.AppendChild(em, c)
+			}
+			p.AppendChild(p, em)
+		}
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	base := t.TempDir()
+	if err := os.WriteFile(filepath.Join(base, "page.md"), []byte("# Title\n\ntext\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		opt    Option
+		want   []string
+		absent []string
+	}{
+		{"default", nil, []string{`<h1 id="title">Title`, `<a class="anchor"`, "<p>text</p>"}, nil},
+		{
+			"markdown options",
+			WithMarkdownOptions(goldmark.WithParserOptions(parser.WithASTTransformers(util.Prioritized(emphasizer{}, 1000)))),
+			[]string{`<a class="anchor"`, "<p><em>text</em></p>"},
+			nil,
+		},
+		{"markdown", WithMarkdown(goldmark.New()), []string{"<h1>Title</h1>", "<p>text</p>"}, []string{`class="anchor"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen []string
+			opts := []Option{WithMiddleware(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					seen = append(seen, r.URL.Path)
+					next.ServeHTTP(w, r)
+				})
+			})}
+			if tt.opt != nil {
+				opts = append(opts, tt.opt)
+			}
+			h, err := New(base, DefaultConfig(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/page.md", nil))
+			body := w.Body.String()
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET /page.md = %d: %s", w.Code, body)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("page doesn't contain %s:\n%s", want, body)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(body, absent) {
+					t.Errorf("page contains %s:\n%s", absent, body)
+				}
+			}
+			if len(seen) != 1 || seen[0] != "/page.md" {
+				t.Errorf("middleware saw %v, want [/page.md]", seen)
+			}
+		})
+	}
+}
+
+func TestNewFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.md":     {Data: []byte("# Home\n")},
+		"docs/page.md": {Data: []byte("# Page\n\n[Home](../index.md)\n")},
+		"docs/a.txt":   {Data: []byte("plain")},
+	}
+	h, err := NewFS(fsys, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "<title>Home</title>"},
+		{"/docs/page.md", "<title>Page</title>"},
+		{"/docs/a.txt", "plain"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path, "")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("GET %s = %d, want %d with %s:\n%s", tt.path, w.Code, http.StatusOK, tt.want, w.Body)
+		}
+	}
+}
+
+// shoutRenderer renders pages as their source in upper case.
+type shoutRenderer struct{}
+
+func (shoutRenderer) Render(w io.Writer, src []byte, _ parser.Context) error {
+	_, err := fmt.Fprintf(w, "<p>%s</p>", bytes.ToUpper(bytes.TrimSpace(src)))
+	return err
+}
+
+func TestRenderers(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"page.adoc":                    "# Title\n",
+		"notes.txt":                    "quiet\n",
+		"md/" + dirConfigFileName:      "renderers:\n  adoc: markdown\n",
+		"md/page.adoc":                 "# Title\n",
+		"invalid/" + dirConfigFileName: "renderers:\n  .txt: markdown\n",
+		"invalid/notes.txt":            "quiet\n",
+		"invalid/page.adoc":            "# Title\n",
+	})
+	cfg := DefaultConfig()
+	cfg.Renderers = map[string]string{".adoc": "asciidoc"}
+	cfg.AsciidocCommand = "cat"
+	tests := []struct {
+		path string
+		// want is in the page, and withRenderer in the page served with WithRenderer.
+		want, withRenderer string
+	}{
+		{"/page.adoc", "# Title", "# Title"},
+		{"/md/page.adoc", `<h1 id="title">Title`, `<h1 id="title">Title`},
+		// Directories can only pick renderers for the files rendered as pages at the top
+		{"/invalid/page.adoc", "# Title", "# Title"},
+		{"/notes.txt", "quiet", "<p>QUIET</p>"},
+		{"/invalid/notes.txt", "quiet", "<p>QUIET</p>"},
+	}
+	for _, withRenderer := range []bool{false, true} {
+		var opts []Option
+		if withRenderer {
+			opts = append(opts, WithRenderer("TXT", shoutRenderer{}))
+		}
+		h, err := New(base, cfg, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			want := tt.want
+			if withRenderer {
+				want = tt.withRenderer
+			}
+			t.Run(tt.path, func(t *testing.T) {
+				w := get(h, tt.path, "")
+				if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, want) {
+					t.Errorf("GET %s with WithRenderer %v = %d, want %d with %s:\n%s", tt.path, withRenderer, w.Code, http.StatusOK, want, body)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package mdssr
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// defaultMermaidJS is the mermaid build included on pages that contain diagrams.
+const defaultMermaidJS = "https://cdn.jsdelivr.net/npm/mermaid@11/dist/mermaid.min.js"
+
+// KindMermaid is the node kind of a mermaid diagram in the AST.
+var KindMermaid = ast.NewNodeKind("Mermaid")
+
+// mermaidBlock holds the source of a ```mermaid fenced code block.
+type mermaidBlock struct {
+	ast.BaseBlock
+}
+
+func (n *mermaidBlock) Kind() ast.NodeKind {
+	return KindMermaid
+}
+
+func (n *mermaidBlock) IsRaw() bool {
+	return true
+}
+
+func (n *mermaidBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mermaidExtension renders ```mermaid code blocks as <pre class="mermaid"> elements
+// and adds the mermaid script to pages that contain at least one of them.
+type mermaidExtension struct {
+	script string
+}
+
+func (e *mermaidExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(e, 300)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(e, 300)))
+}
+
+func (e *mermaidExtension) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var blocks []*ast.FencedCodeBlock
+
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if block, ok := n.(*ast.FencedCodeBlock); ok && entering && string(block.Language(source)) == "mermaid" {
+			blocks = append(blocks, block)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	if len(blocks) == 0 {
+		return
+	}
+
+	for _, block := range blocks {
+		diagram := &mermaidBlock{}
+		diagram.SetLines(block.Lines())
+		block.Parent().ReplaceChild(block.Parent(), block, diagram)
+	}
+	addPageAssets(pc, nil, []string{e.script})
+}
+
+func (e *mermaidExtension) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMermaid, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			_, _ = w.WriteString("</pre>\n")
+			return ast.WalkContinue, nil
+		}
+		_, _ = w.WriteString(`<pre class="mermaid">`)
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			line := lines.At(i)
+			_, _ = w.Write(util.EscapeHTML(line.Value(source)))
+		}
+		return ast.WalkContinue, nil
+	})
+}
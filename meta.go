@@ -0,0 +1,190 @@
+package mdssr
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// maxSummaryLength is the number of characters of the first paragraph used as
+// the description of pages that don't have one.
+const maxSummaryLength = 160
+
+// Template for the description, Open Graph and Twitter card tags of a page.
+const metaTemplate = `
+{{- with .Description }}
+    <meta name="description" content="{{ . }}">
+{{- end }}
+{{- with .Author }}
+    <meta name="author" content="{{ . }}">
+{{- end }}
+    <meta property="og:title" content="{{ .Title }}">
+    <meta property="og:type" content="{{ if .Date.IsZero }}website{{ else }}article{{ end }}">
+{{- with .URL }}
+    <meta property="og:url" content="{{ . }}">
+{{- end }}
+{{- with .Description }}
+    <meta property="og:description" content="{{ . }}">
+{{- end }}
+{{- with .Image }}
+    <meta property="og:image" content="{{ . }}">
+{{- end }}
+{{- if not .Date.IsZero }}
+    <meta property="article:published_time" content="{{ .Date.Format "2006-01-02T15:04:05Z07:00" }}">
+{{- end }}
+    <meta name="twitter:card" content="{{ if .Image }}summary_large_image{{ else }}summary{{ end }}">
+    <meta name="twitter:title" content="{{ .Title }}">
+{{- with .Description }}
+    <meta name="twitter:description" content="{{ . }}">
+{{- end }}
+{{- with .Image }}
+    <meta name="twitter:image" content="{{ . }}">
+{{- end }}`
+
+var metaTmpl = template.Must(template.New("meta").Parse(metaTemplate))
+
+// summaryKey stores the text of the first paragraph of the page being parsed,
+// and headingKey that of its first level-one heading.
+var (
+	summaryKey = parser.NewContextKey()
+	headingKey = parser.NewContextKey()
+)
+
+// summaryTransformer records the text of the first paragraph, for pages
+// without a description in their front matter, and of the first level-one
+// heading, for pages without a title in their front matter or a # header.
+type summaryTransformer struct{}
+
+func (summaryTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			if heading := strings.Join(strings.Fields(nodeText(n, source)), " "); n.Level == 1 && heading != "" && pc.Get(headingKey) == nil {
+				pc.Set(headingKey, heading)
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.Paragraph:
+			summary := strings.Join(strings.Fields(nodeText(n, source)), " ")
+			if summary == "" || summary == tocMarker {
+				return ast.WalkSkipChildren, nil
+			}
+			if pc.Get(summaryKey) == nil {
+				pc.Set(summaryKey, truncate(summary, maxSummaryLength))
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		if pc.Get(summaryKey) != nil && pc.Get(headingKey) != nil {
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// summaryFromContext returns the summary recorded while parsing with pc.
+func summaryFromContext(pc parser.Context) string {
+	summary, _ := pc.Get(summaryKey).(string)
+	return summary
+}
+
+// headingFromContext returns the first level-one heading recorded while parsing with pc.
+func headingFromContext(pc parser.Context) string {
+	heading, _ := pc.Get(headingKey).(string)
+	return heading
+}
+
+// nodeText returns the text within n without markup.
+func nodeText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	_ = ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		case *ast.CodeSpan:
+			b.Write(n.Text(source))
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return b.String()
+}
+
+// truncate shortens s to at most n characters, cutting at a word boundary.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// metaTags renders the meta tags of data. Relative URLs are made absolute
+// against origin, as link previews need them to be. Without an origin, the
+// page URL is left out.
+func metaTags(data PageData, origin string) template.HTML {
+	meta := struct {
+		Title, Description, Author, URL, Image string
+		Date                                   time.Time
+	}{
+		Title:       data.Title,
+		Description: data.Description,
+		Author:      data.Author,
+		Date:        data.Date,
+		Image:       absoluteURL(origin, data.URL, data.Image),
+	}
+	if origin != "" {
+		meta.URL = origin + data.URL
+	}
+
+	var buf bytes.Buffer
+	_ = metaTmpl.Execute(&buf, meta)
+	return template.HTML(buf.String())
+}
+
+// absoluteURL resolves ref against the page at pageURL on origin. Without an
+// origin, ref is only resolved to a root-relative path.
+func absoluteURL(origin, pageURL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	base, err := url.Parse(origin + pageURL)
+	if err != nil {
+		return ref
+	}
+	resolved, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return resolved.String()
+}
+
+// requestOrigin returns the scheme and host the request was made to.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,145 @@
+package mdssr
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Paths of the monitoring endpoints.
+const (
+	metricsPath = "/metrics"
+	healthPath  = "/healthz"
+	readyPath   = "/readyz"
+)
+
+// renderBuckets are the upper bounds in seconds of the render latency histogram.
+var renderBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// serverMetrics counts what the server does, for the Prometheus endpoint.
+type serverMetrics struct {
+	requests    atomic.Int64
+	inFlight    atomic.Int64
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+
+	mu sync.Mutex
+	// renderCounts holds the number of renders per bucket of renderBuckets, and
+	// one for slower renders.
+	renderCounts []int64
+	renderCount  int64
+	renderSum    float64
+}
+
+// metrics is shared by every handler of the process.
+var metrics = &serverMetrics{renderCounts: make([]int64, len(renderBuckets)+1)}
+
+// ready reports whether the server accepts requests, for the readiness endpoint.
+var ready atomic.Bool
+
+// observeRender records a page rendered in d.
+func (m *serverMetrics) observeRender(d time.Duration) {
+	seconds := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := 0
+	for i < len(renderBuckets) && seconds > renderBuckets[i] {
+		i++
+	}
+	m.renderCounts[i]++
+	m.renderCount++
+	m.renderSum += seconds
+}
+
+// countRequests returns middleware counting the requests served and in flight.
+func (m *serverMetrics) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.requests.Add(1)
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *serverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	counter := func(name, help string, value int64) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	counter("mdssr_requests_total", "Requests served.", m.requests.Load())
+	fmt.Fprintf(&buf, "# HELP mdssr_requests_in_flight Requests being served.\n# TYPE mdssr_requests_in_flight gauge\nmdssr_requests_in_flight %d\n", m.inFlight.Load())
+	counter("mdssr_cache_hits_total", "Pages served from the render cache.", m.cacheHits.Load())
+	counter("mdssr_cache_misses_total", "Pages not in the render cache or stale.", m.cacheMisses.Load())
+
+	m.mu.Lock()
+	counter("mdssr_renders_total", "Markdown files rendered.", m.renderCount)
+	buf.WriteString("# HELP mdssr_render_duration_seconds Time taken to render markdown files.\n# TYPE mdssr_render_duration_seconds histogram\n")
+	var cumulative int64
+	for i, bound := range renderBuckets {
+		cumulative += m.renderCounts[i]
+		fmt.Fprintf(&buf, "mdssr_render_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(&buf, "mdssr_render_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.renderCount)
+	fmt.Fprintf(&buf, "mdssr_render_duration_seconds_sum %g\nmdssr_render_duration_seconds_count %d\n", m.renderSum, m.renderCount)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// serveHealth answers liveness checks: the process is up.
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// serveReady answers readiness checks: the server accepts requests and isn't shutting down.
+func serveReady(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	serveHealth(w, r)
+}
+
+// registerMonitoring adds the monitoring and admin endpoints cfg enables to the default
+// mux, or with an admin address to a server of their own on it.
+func registerMonitoring(cfg Config) error {
+	if !cfg.Metrics && !cfg.Health && cfg.AdminToken == "" {
+		return nil
+	}
+	mux := http.DefaultServeMux
+	if cfg.AdminListen != "" {
+		mux = http.NewServeMux()
+	}
+	if cfg.Metrics {
+		mux.Handle(metricsPath, metrics)
+	}
+	if cfg.Health {
+		mux.HandleFunc(healthPath, serveHealth)
+		mux.HandleFunc(readyPath, serveReady)
+	}
+	if cfg.AdminToken != "" {
+		mux.Handle(adminPurgePath, adminHandler{cfg.AdminToken})
+		mux.Handle(adminPurgeAllPath, adminHandler{cfg.AdminToken})
+	}
+	if cfg.AdminListen == "" {
+		return nil
+	}
+
+	l, err := listenAddr(cfg.AdminListen)
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving monitoring and admin endpoints on %s\n", listenerURL("http", l))
+	go func() {
+		log.Fatal(http.Serve(l, mux))
+	}()
+	return nil
+}
@@ -0,0 +1,186 @@
+package mdssr
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Mount is a content tree served next to the base path, under a URL path or
+// for a host name.
+type Mount struct {
+	// Path is the URL path the tree is served under, / by default.
+	Path string `yaml:"path"`
+	// Host, if set, only serves the tree for requests to that host.
+	Host string `yaml:"host"`
+	Root string `yaml:"root"`
+
+	// Options replacing the top-level ones for this tree
+	CSS      []string `yaml:"css"`
+	JS       []string `yaml:"js"`
+	Template string   `yaml:"template"`
+	Theme    string   `yaml:"theme"`
+}
+
+// parseMount parses a --mount value of the form path=root.
+func parseMount(s string) (Mount, error) {
+	path, root, ok := strings.Cut(s, "=")
+	if !ok || path == "" || root == "" {
+		return Mount{}, fmt.Errorf("invalid mount %q, expected /path=root", s)
+	}
+	return Mount{Path: path, Root: root}, nil
+}
+
+// pattern returns the ServeMux pattern matching the requests for m.
+func (m Mount) pattern() string {
+	return m.Host + m.prefix() + "/"
+}
+
+// prefix returns the URL path of the mount without a trailing slash.
+func (m Mount) prefix() string {
+	return strings.TrimSuffix(m.Path, "/")
+}
+
+// config returns cfg with the options set on m replacing the top-level ones.
+func (m Mount) config(cfg Config) Config {
+	if len(m.CSS) > 0 {
+		cfg.CSS = m.CSS
+	}
+	if len(m.JS) > 0 {
+		cfg.JS = m.JS
+	}
+	if m.Template != "" {
+		cfg.Template = m.Template
+	}
+	if m.Theme != "" {
+		cfg.Theme = m.Theme
+	}
+	// Only the base path is a checkout of --git-url
+	if m.Host != "" || m.prefix() != "" {
+		cfg.GitURL = ""
+	}
+	cfg.Mounts = nil
+	return cfg
+}
+
+// validate checks m and fills in its defaults.
+func (m *Mount) validate() error {
+	if m.Root == "" {
+		return fmt.Errorf("mount %s%s has no root", m.Host, m.Path)
+	}
+	if m.Path == "" {
+		m.Path = "/"
+	}
+	if !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("mount path %q must start with /", m.Path)
+	}
+	if m.Host == "" && m.prefix() == "" {
+		return fmt.Errorf("mount %s conflicts with the base path", m.Root)
+	}
+	root, err := filepath.Abs(m.Root)
+	if err != nil {
+		return err
+	}
+	m.Root = root
+	return nil
+}
+
+// serveMount registers the handler for the tree of m on the default mux.
+// load returns the options after a SIGHUP; lr, if set, is told about changes to the tree.
+func serveMount(m Mount, cfg Config, load func() (Config, error), lr *liveReload) error {
+	newHandler := func(cfg Config) (*markdownHandler, error) {
+		cfg = m.config(cfg)
+		if lr != nil {
+			cfg.JS = append(slices.Clone(cfg.JS), liveReloadScript)
+		}
+		h, err := newMarkdownHandler(m.Root, cfg)
+		if err != nil {
+			return nil, err
+		}
+		h.prefix = cfg.BaseURL + m.prefix()
+		if cfg.Prerender {
+			go h.prerender()
+		}
+		return h, nil
+	}
+
+	h, err := newHandler(cfg)
+	if err != nil {
+		return err
+	}
+	site := &siteHandler{}
+	site.current.Store(h)
+	registerPurgeTarget(site)
+
+	// Pick up changes to the config file and template on SIGHUP.
+	// Server options such as --listen and --watch, and the set of mounts, only change on restart.
+	reloadOnHangup(site, func() (*markdownHandler, error) {
+		cfg, err := load()
+		if err != nil {
+			return nil, err
+		}
+		if i := slices.IndexFunc(cfg.Mounts, func(other Mount) bool { return other.pattern() == m.pattern() }); i >= 0 {
+			m = cfg.Mounts[i]
+		}
+		return newHandler(cfg)
+	})
+
+	// Watch the tree to reload browsers and keep the indexes fresh
+	if cfg.watchesTree() {
+		err := watchTree(m.Root, func() {
+			site.contentChanged()
+			if lr != nil {
+				lr.broadcast()
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("watching %s: %w", m.Root, err)
+		}
+	}
+
+	var handler http.Handler = site
+	if prefix := m.prefix(); prefix != "" {
+		handler = http.StripPrefix(prefix, site)
+	}
+	http.Handle(m.pattern(), handler)
+
+	// Host patterns take precedence over the live reload endpoints registered without one
+	if m.Host != "" && lr != nil {
+		http.Handle(m.Host+liveReloadPath, lr)
+		http.Handle(m.Host+liveReloadScript, lr)
+	}
+
+	if m.Host != "" || m.prefix() != "" {
+		log.Printf("Serving %s at %s\n", m.Root, m.Host+m.Path)
+	}
+	return nil
+}
+
+// mountFlag is a flag.Value for a comma-separated list of mounts. Unlike other
+// lists, repeating the flag adds to the mounts rather than replacing them.
+type mountFlag []Mount
+
+func (f *mountFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	values := make([]string, len(*f))
+	for i, m := range *f {
+		values[i] = m.Path + "=" + m.Root
+	}
+	return strings.Join(values, ",")
+}
+
+func (f *mountFlag) Set(s string) error {
+	for _, value := range parseSources(s) {
+		m, err := parseMount(value)
+		if err != nil {
+			return err
+		}
+		*f = append(*f, m)
+	}
+	return nil
+}
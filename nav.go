@@ -0,0 +1,260 @@
+package mdssr
+
+import (
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// orderFileName is the file listing the order of a directory's entries in the navigation.
+// It holds a YAML list of file and directory names; unlisted entries follow.
+const orderFileName = "_order.yaml"
+
+// NavItem is a page or directory in the navigation tree.
+type NavItem struct {
+	Title string
+	// URL is empty for directories that have no page of their own.
+	URL      string
+	Children []NavItem
+
+	// Active is set on the item of the current page.
+	Active bool
+	// Open is set on the items that contain the current page.
+	Open bool
+
+	weight int
+	name   string
+}
+
+// navIndex caches the navigation tree until the content changes.
+type navIndex struct {
+	mu    sync.Mutex
+	stale bool
+	items []NavItem
+}
+
+// invalidate marks the tree for rebuilding on next use.
+func (n *navIndex) invalidate() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stale = true
+}
+
+// navFor returns the navigation tree with the page at url marked active.
+func (h *markdownHandler) navFor(url string) []NavItem {
+	h.nav.mu.Lock()
+	if h.nav.stale || h.nav.items == nil {
+		items, err := h.buildNav(h.basePath, "")
+		if err != nil {
+			log.Printf("Error building navigation: %v\n", err)
+		}
+		h.nav.items = items
+		h.nav.stale = false
+	}
+	items := h.nav.items
+	h.nav.mu.Unlock()
+
+	marked, _ := markActive(items, url)
+	return marked
+}
+
+// buildNav returns the navigation items for dir, whose slash-separated path relative
+// to the base path is rel. Each directory is represented by its index page.
+func (h *markdownHandler) buildNav(dir, rel string) ([]NavItem, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []NavItem
+	for _, entry := range entries {
+		name := entry.Name()
+		if isInternal(name) {
+			continue
+		}
+		if _, err := h.containedPath(filepath.Join(dir, name)); err != nil {
+			continue
+		}
+		entryRel := path.Join(rel, name)
+
+		if entry.IsDir() {
+			item := NavItem{Title: name, name: name}
+			indexPath, _, hasIndex := h.indexFile(filepath.Join(dir, name))
+			if fm, body, err := readMarkdown(indexPath); hasIndex && err == nil && h.cfg.published(fm) {
+				if title, ok := pageTitle(fm, body); ok {
+					item.Title = title
+				}
+				item.weight = fm.Weight
+				item.URL = h.prefix + "/" + entryRel + "/"
+			} else if h.cfg.AutoIndex {
+				item.URL = h.prefix + "/" + entryRel + "/"
+			}
+
+			children, err := h.buildNav(filepath.Join(dir, name), entryRel)
+			if err != nil {
+				return nil, err
+			}
+			item.Children = children
+
+			// Leave out directories without any pages
+			if item.URL != "" || len(item.Children) > 0 {
+				items = append(items, item)
+			}
+			continue
+		}
+
+		if !h.cfg.isMarkdown(name) || h.isIndexFile(filepath.Join(dir, name)) {
+			continue
+		}
+
+		item := NavItem{Title: h.cfg.pageName(name), URL: h.pageURL(entryRel), name: name}
+		if fm, body, err := readMarkdown(filepath.Join(dir, name)); err == nil {
+			if !h.cfg.published(fm) {
+				continue
+			}
+			if title, ok := pageTitle(fm, body); ok {
+				item.Title = title
+			}
+			item.weight = fm.Weight
+		}
+		items = append(items, item)
+	}
+
+	sortNav(items, readOrder(filepath.Join(dir, orderFileName)))
+	return items, nil
+}
+
+// readOrder reads the entry names listed in an order file, if there is one.
+func readOrder(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var order []string
+	if err := yaml.Unmarshal(data, &order); err != nil {
+		log.Printf("Error parsing %s: %v\n", path, err)
+		return nil
+	}
+	for i := range order {
+		order[i] = strings.TrimSuffix(order[i], "/")
+	}
+	return order
+}
+
+// sortNav orders items as listed in order, then by front matter weight, then by title.
+// Items without a weight come after weighted ones.
+func sortNav(items []NavItem, order []string) {
+	position := make(map[string]int, len(order))
+	for i, name := range order {
+		position[name] = i
+	}
+	rank := func(item NavItem) (int, int) {
+		pos, ok := position[item.name]
+		if !ok {
+			pos = len(order)
+		}
+		weight := item.weight
+		if weight == 0 {
+			weight = math.MaxInt
+		}
+		return pos, weight
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		pi, wi := rank(items[i])
+		pj, wj := rank(items[j])
+		if pi != pj {
+			return pi < pj
+		}
+		if wi != wj {
+			return wi < wj
+		}
+		return strings.ToLower(items[i].Title) < strings.ToLower(items[j].Title)
+	})
+}
+
+// markActive returns a copy of items with the item at url marked active and its
+// ancestors marked open. It reports whether url was found.
+func markActive(items []NavItem, url string) ([]NavItem, bool) {
+	marked := make([]NavItem, len(items))
+	found := false
+	for i, item := range items {
+		item.Active = item.URL != "" && item.URL == url
+		item.Children, item.Open = markActive(item.Children, url)
+		found = found || item.Active || item.Open
+		marked[i] = item
+	}
+	return marked, found
+}
+
+// homeNav returns the item of the root's index page, which the navigation tree
+// leaves out, if there is one.
+func (h *markdownHandler) homeNav() []NavItem {
+	index, _, ok := h.indexFile(h.basePath)
+	if !ok {
+		return nil
+	}
+	item := NavItem{Title: "Home", URL: h.prefix + "/"}
+	if fm, body, err := readMarkdown(index); err == nil {
+		if title, ok := pageTitle(fm, body); ok {
+			item.Title = title
+		}
+	}
+	return []NavItem{item}
+}
+
+// neighbours returns the pages before and after the page at url when reading
+// items in order, each directory's page followed by its children.
+func neighbours(items []NavItem, url string) (prev, next *NavItem) {
+	var pages []NavItem
+	var flatten func([]NavItem)
+	flatten = func(items []NavItem) {
+		for _, item := range items {
+			children := item.Children
+			if item.URL != "" {
+				item.Children = nil
+				pages = append(pages, item)
+			}
+			flatten(children)
+		}
+	}
+	flatten(items)
+
+	for i := range pages {
+		if pages[i].URL != url {
+			continue
+		}
+		if i > 0 {
+			prev = &pages[i-1]
+		}
+		if i+1 < len(pages) {
+			next = &pages[i+1]
+		}
+		break
+	}
+	return prev, next
+}
+
+// filterNav returns the items whose URL passes allowed. Directories without a
+// page of their own are kept as long as some of their children are.
+func filterNav(items []NavItem, allowed func(url string) bool) []NavItem {
+	filtered := []NavItem{}
+	for _, item := range items {
+		item.Children = filterNav(item.Children, allowed)
+		if item.URL != "" && !allowed(item.URL) {
+			continue
+		}
+		if item.URL == "" && len(item.Children) == 0 {
+			continue
+		}
+		filtered = append(filtered, item)
+	}
+	return filtered
+}
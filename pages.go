@@ -0,0 +1,101 @@
+package mdssr
+
+import (
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pageSummary describes a page for listings such as feeds.
+type pageSummary struct {
+	URL         string
+	Title       string
+	Description string
+	Author      string
+	// Date is the date from the front matter, or else the file's modification time.
+	Date       time.Time
+	ModTime    time.Time
+	Tags       []string
+	Categories []string
+	Params     map[string]any
+}
+
+// pageIndex holds the summaries of the pages in the tree, newest first.
+// It is built on first use and rebuilt lazily after the tree changes.
+type pageIndex struct {
+	mu    sync.Mutex
+	stale bool
+	pages []pageSummary
+	err   error
+}
+
+// invalidate marks the index for rebuilding on next use.
+func (idx *pageIndex) invalidate() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.stale = true
+}
+
+// listPages returns the pages in the tree that pass allowed, newest first.
+// Error pages and unpublished pages are left out.
+func (h *markdownHandler) listPages(allowed func(url string) bool) ([]pageSummary, error) {
+	h.pages.mu.Lock()
+	if h.pages.stale || h.pages.pages == nil {
+		h.pages.pages, h.pages.err = h.buildPages()
+		h.pages.stale = false
+	}
+	all, err := h.pages.pages, h.pages.err
+	h.pages.mu.Unlock()
+
+	var pages []pageSummary
+	for _, page := range all {
+		if allowed(page.URL) {
+			pages = append(pages, page)
+		}
+	}
+	return pages, err
+}
+
+// buildPages summarizes every published page in the tree, newest first.
+func (h *markdownHandler) buildPages() ([]pageSummary, error) {
+	var pages []pageSummary
+	err := h.walkMarkdown(h.basePath, func(file, rel string, d fs.DirEntry) error {
+		if h.cfg.isErrorPage(rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		page, err := h.loadPage(file, info)
+		if err != nil {
+			return err
+		}
+		if !h.cfg.published(page.Meta) {
+			return nil
+		}
+
+		summary := pageSummary{
+			URL:         h.canonicalURL(rel),
+			Title:       page.Title,
+			Description: page.Meta.Description,
+			Author:      page.Meta.Author,
+			Date:        page.Meta.Date,
+			ModTime:     info.ModTime(),
+			Tags:        page.Meta.Tags,
+			Categories:  page.Meta.Categories,
+			Params:      page.Params,
+		}
+		if summary.Date.IsZero() {
+			summary.Date = info.ModTime()
+		}
+		pages = append(pages, summary)
+		return nil
+	})
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].Date.After(pages[j].Date)
+	})
+	return pages, err
+}
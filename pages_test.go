@@ -0,0 +1,40 @@
+package mdssr
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestListPagesCached(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"old.md": "# Old\n"})
+	cfg := DefaultConfig()
+	cfg.Feed, cfg.Sitemap = true, true
+	h, err := newMarkdownHandler(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(when string, wantNew bool) {
+		t.Helper()
+		for _, path := range []string{feedPath, sitemapPath} {
+			w := get(h, path, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s %s = %d", path, when, w.Code)
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, "/old.md") {
+				t.Errorf("GET %s %s doesn't list /old.md:\n%s", path, when, body)
+			}
+			if listed := strings.Contains(body, "/new.md"); listed != wantNew {
+				t.Errorf("GET %s %s lists /new.md = %v, want %v", path, when, listed, wantNew)
+			}
+		}
+	}
+	check("before adding a page", false)
+	writeFiles(t, base, map[string]string{"new.md": "# New\n"})
+	check("before the tree change was seen", false)
+	h.contentChanged()
+	check("after the tree changed", true)
+}
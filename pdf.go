@@ -0,0 +1,190 @@
+package mdssr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pdfTimeout limits how long the PDF converter may run for a page.
+const pdfTimeout = time.Minute
+
+// maxPDFConversions limits how many converters run at once, and maxPDFEntries
+// how many PDFs are cached.
+const (
+	maxPDFConversions = 2
+	maxPDFEntries     = 256
+)
+
+// pdfSlots holds a token for each converter running.
+var pdfSlots = make(chan struct{}, maxPDFConversions)
+
+// pdfCache keeps the PDF of each page converted, until the page changes.
+type pdfCache struct {
+	mu      sync.Mutex
+	entries map[pdfKey]pdfEntry
+}
+
+// pdfKey identifies what a PDF of a page is rendered from besides the page:
+// the origin and query of the request and the user it was rendered for, as
+// the page template may vary with them.
+type pdfKey struct {
+	path, origin, query, user string
+}
+
+// pdfEntry is the PDF of a page, valid while the page has the same ETag.
+type pdfEntry struct {
+	etag string
+	pdf  []byte
+}
+
+func (c *pdfCache) get(key pdfKey, etag string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.etag != etag {
+		return nil, false
+	}
+	return entry.pdf, true
+}
+
+func (c *pdfCache) put(key pdfKey, etag string, pdf []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[pdfKey]pdfEntry)
+	}
+	// Drop entries at random while the cache is full
+	for stale := range c.entries {
+		if len(c.entries) < maxPDFEntries {
+			break
+		}
+		delete(c.entries, stale)
+	}
+	c.entries[key] = pdfEntry{etag: etag, pdf: pdf}
+}
+
+// servePDF converts page, rendered from the file at path, to PDF with the
+// configured converter and writes it.
+func (h *markdownHandler) servePDF(w http.ResponseWriter, r *http.Request, path string, info os.FileInfo, page *renderedPage) {
+	etag := strings.TrimSuffix(h.etag(r, path, info, page.Includes...), `"`) + `-pdf"`
+	key := pdfKey{path: path, origin: h.siteOrigin(r), query: r.URL.RawQuery}
+	if h.auth != nil {
+		key.user, _ = h.auth.authenticate(r)
+	}
+	pdf, ok := h.pdfs.get(key, etag)
+	if !ok {
+		select {
+		case pdfSlots <- struct{}{}:
+			defer func() { <-pdfSlots }()
+		case <-r.Context().Done():
+			return
+		}
+		body, err := h.executeFor(r, page.pageData())
+		if err == nil {
+			if origin := h.pdfOrigin(r); origin != "" {
+				body = withBase(body, origin+h.prefix+r.URL.Path)
+			}
+			pdf, err = convertPDF(r.Context(), h.cfg.PDFCommand, body)
+		}
+		if err != nil {
+			h.serverError(w, r, "Error converting page to PDF")
+			log.Printf("Error converting %s to PDF: %v\n", path, err)
+			return
+		}
+		h.pdfs.put(key, etag, pdf)
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/pdf")
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".pdf"
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+	http.ServeContent(w, r, path, h.lastModified(info), bytes.NewReader(pdf))
+}
+
+// pdfOrigin returns the origin the converter loads the assets of pages from:
+// the site URL, or else the address of the server r came in on. Unlike the
+// Host header, neither is up to the client. It is empty if neither is known.
+func (h *markdownHandler) pdfOrigin(r *http.Request) string {
+	if h.cfg.SiteURL != "" {
+		return strings.TrimSuffix(h.cfg.SiteURL, "/")
+	}
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok || addr.Network() != "tcp" {
+		return ""
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + addr.String()
+}
+
+// withBase adds a base element pointing at url to the head of the page, so the
+// converter can load relative stylesheets, scripts and images from the server.
+func withBase(page []byte, url string) []byte {
+	base := []byte(`<base href="` + html.EscapeString(url) + `">`)
+	if i := bytes.Index(page, []byte("<head>")); i >= 0 {
+		i += len("<head>")
+		return append(append(append([]byte(nil), page[:i]...), base...), page[i:]...)
+	}
+	return append(base, page...)
+}
+
+// convertPDF runs command, split at spaces, to convert the HTML page to PDF.
+// The {input} and {output} placeholders are replaced with the paths of temporary
+// files holding the page and receiving the PDF; without them the page is written
+// to the command's standard input and the PDF read from its standard output,
+// as with "wkhtmltopdf - -".
+func convertPDF(ctx context.Context, command string, page []byte) ([]byte, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no PDF converter configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, pdfTimeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "mdssr-pdf-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input, output := filepath.Join(dir, "page.html"), filepath.Join(dir, "page.pdf")
+
+	usesFiles := false
+	for i, arg := range args {
+		if strings.Contains(arg, "{input}") || strings.Contains(arg, "{output}") {
+			usesFiles = true
+			args[i] = strings.NewReplacer("{input}", input, "{output}", output).Replace(arg)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if usesFiles {
+		if err := os.WriteFile(input, page, 0o600); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd.Stdin = bytes.NewReader(page)
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	if !usesFiles {
+		return stdout.Bytes(), nil
+	}
+	return os.ReadFile(output)
+}
@@ -0,0 +1,60 @@
+package mdssr
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// prerender renders every markdown page under the base path with a pool of
+// workers, one per CPU, logging the pages that fail to render. With --cache,
+// the pages are kept, so the first visitors don't wait for them to render.
+func (h *markdownHandler) prerender() {
+	start := time.Now()
+	files := make(chan string)
+	var rendered, failed atomic.Int64
+	var wg sync.WaitGroup
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				if err := h.prerenderPage(file); err != nil {
+					log.Printf("Error rendering %s: %v\n", file, err)
+					failed.Add(1)
+					continue
+				}
+				rendered.Add(1)
+			}
+		}()
+	}
+
+	err := h.walkMarkdown(h.basePath, func(file, _ string, _ fs.DirEntry) error {
+		files <- file
+		return nil
+	})
+	close(files)
+	wg.Wait()
+	if err != nil {
+		log.Printf("Error pre-rendering %s: %v\n", h.basePath, err)
+	}
+	log.Printf("Pre-rendered %d pages of %s in %v, %d failed\n", rendered.Load(), h.basePath, time.Since(start).Round(time.Millisecond), failed.Load())
+}
+
+// prerenderPage renders the page at file through the cache.
+func (h *markdownHandler) prerenderPage(file string) error {
+	path, err := h.containedPath(file)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	_, err = h.loadPage(path, info)
+	return err
+}
@@ -0,0 +1,125 @@
+package mdssr
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucketIdle is how long a client's bucket is kept once it is full again.
+const bucketIdle = time.Minute
+
+// rateLimiter limits the requests of each client with a token bucket: a client
+// may send burst requests at once, and rate requests per second after that.
+type rateLimiter struct {
+	rate       float64
+	burst      float64
+	trustProxy bool
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// bucket holds the tokens a client has left, as of last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int, trustProxy bool) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(max(burst, 1)), trustProxy: trustProxy, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the bucket of client. If there is none, it returns
+// false and how long until there is.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets of clients that have been quiet long enough to be
+// full again, so the map doesn't grow with every address ever seen.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < bucketIdle {
+		return
+	}
+	l.swept = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > full+bucketIdle {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// middleware answers requests beyond the limit with 429 Too Many Requests.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow(clientIP(r, l.trustProxy), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the address of the client sending r. Behind a trusted proxy
+// it is the last address in X-Forwarded-For, the one the proxy added.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			list := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(list[len(list)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitRequests returns middleware rejecting requests whose URL is longer than
+// maxURL bytes with 414 URI Too Long, and whose body is larger than maxBody bytes
+// with 413 Content Too Large. Zero disables either limit.
+func limitRequests(maxURL int, maxBody int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxURL > 0 && len(r.RequestURI) > maxURL {
+				http.Error(w, "URI Too Long", http.StatusRequestURITooLong)
+				return
+			}
+			if maxBody > 0 {
+				if r.ContentLength > maxBody {
+					http.Error(w, "Content Too Large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
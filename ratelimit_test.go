@@ -0,0 +1,120 @@
+package mdssr
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3, false)
+	now := time.Now()
+	for i := range 3 {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	if ok, wait := l.allow("a", now); ok || wait != 500*time.Millisecond {
+		t.Errorf("request after the burst = %v, %v, want false, 500ms", ok, wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("request of another client refused")
+	}
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("request after waiting refused")
+	}
+
+	// Idle clients are forgotten
+	l.allow("a", now.Add(time.Hour))
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("bucket of an idle client kept")
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name       string
+		trustProxy bool
+		// forwarded are the X-Forwarded-For headers of the second request,
+		// sent from the same address as the first.
+		forwarded []string
+		want      int
+	}{
+		{"same client", false, nil, http.StatusTooManyRequests},
+		{"forwarded without trusting the proxy", false, []string{"198.51.100.2"}, http.StatusTooManyRequests},
+		{"forwarded by a trusted proxy", true, []string{"203.0.113.9, 198.51.100.2"}, http.StatusOK},
+		// Clients can't pick the address a trusted proxy adds last
+		{"spoofed behind a trusted proxy", true, []string{"198.51.100.2, 192.0.2.1"}, http.StatusTooManyRequests},
+		{"spoofed header behind a trusted proxy", true, []string{"198.51.100.2", "192.0.2.1"}, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newRateLimiter(1, 1, tt.trustProxy).middleware(ok)
+			first := httptest.NewRequest(http.MethodGet, "/", nil)
+			first.Header.Set("X-Forwarded-For", "192.0.2.1")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, first)
+			if w.Code != http.StatusOK {
+				t.Fatalf("first request = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, forwarded := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", forwarded)
+			}
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("second request = %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+				t.Errorf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestLimitRequests(t *testing.T) {
+	var readErr error
+	h := limitRequests(32, 8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		// chunked sends the body without a Content-Length.
+		chunked bool
+		want    int
+		tooLong bool
+	}{
+		{"short", "/page.md", "12345678", false, http.StatusOK, false},
+		{"long URL", "/" + strings.Repeat("a", 32), "", false, http.StatusRequestURITooLong, false},
+		{"long query", "/?q=" + strings.Repeat("a", 29), "", false, http.StatusRequestURITooLong, false},
+		{"large body", "/", "123456789", false, http.StatusRequestEntityTooLarge, false},
+		{"large chunked body", "/", "123456789", true, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readErr = nil
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("POST %s = %d, want %d", tt.target, w.Code, tt.want)
+			}
+			var maxBytes *http.MaxBytesError
+			if tooLong := errors.As(readErr, &maxBytes); tooLong != tt.tooLong {
+				t.Errorf("reading the body of POST %s failed with %v, want the body cut short: %v", tt.target, readErr, tt.tooLong)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package mdssr
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// recentPath is where the recently updated pages are listed with --recent.
+const recentPath = "/_recent"
+
+// Template for the listing of recently updated pages.
+const recentTemplate = `<ul class="posts recent">
+    {{- range . }}
+    <li>
+        <a href="{{ .URL }}">{{ .Title }}</a>
+        <time datetime="{{ .ModTime.Format "2006-01-02T15:04:05Z07:00" }}">{{ .ModTime.Format "January 2, 2006 15:04" }}</time>
+    </li>
+    {{- end }}
+</ul>`
+
+var recentTmpl = template.Must(template.New("recent").Parse(recentTemplate))
+
+// recentIndex holds the pages of the tree, most recently modified first.
+// It is built on first use and rebuilt lazily after the tree changes.
+type recentIndex struct {
+	mu    sync.Mutex
+	stale bool
+	pages []pageSummary
+}
+
+// invalidate marks the index for rebuilding on next use.
+func (idx *recentIndex) invalidate() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.stale = true
+}
+
+// recentPages returns the --recent most recently modified pages that pass allowed.
+func (h *markdownHandler) recentPages(allowed func(url string) bool) []pageSummary {
+	h.recent.mu.Lock()
+	if h.recent.stale || h.recent.pages == nil {
+		pages, err := h.listPages(func(string) bool { return true })
+		if err != nil {
+			log.Printf("Error indexing recent pages: %v\n", err)
+		}
+		sort.SliceStable(pages, func(i, j int) bool {
+			return pages[i].ModTime.After(pages[j].ModTime)
+		})
+		h.recent.pages = pages
+		h.recent.stale = false
+	}
+	pages := h.recent.pages
+	h.recent.mu.Unlock()
+
+	recent := []pageSummary{}
+	for _, page := range pages {
+		if len(recent) == h.cfg.Recent {
+			break
+		}
+		if allowed(page.URL) {
+			recent = append(recent, page)
+		}
+	}
+	return recent
+}
+
+// recentJSON is a recently updated page as served at recentPath as JSON.
+type recentJSON struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Modified    time.Time `json:"modified"`
+}
+
+// serveRecent lists the recently updated pages the visitor may read, as JSON
+// if asked for.
+func (h *markdownHandler) serveRecent(w http.ResponseWriter, r *http.Request) {
+	pages := h.recentPages(func(url string) bool { return h.allowed(r, url) })
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		results := make([]recentJSON, 0, len(pages))
+		for _, page := range pages {
+			results = append(results, recentJSON{page.URL, page.Title, page.Description, page.ModTime})
+		}
+		_ = json.NewEncoder(w).Encode(results)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := recentTmpl.Execute(&buf, pages); err != nil {
+		h.serverError(w, r, "Error listing recent pages")
+		log.Printf("Error executing recent template: %v\n", err)
+		return
+	}
+	data := PageData{Title: "Recently updated", Content: template.HTML(buf.String())}
+	data.Recent = pages
+	h.renderPage(w, r, recentPath, data, time.Time{})
+}
@@ -0,0 +1,250 @@
+package mdssr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// redirectsFileName is the file in the base path listing the redirects served
+// with --redirects, a rule per line in the format of Netlify's _redirects:
+//
+//	/old/page    /new/page
+//	/old/docs/*  /docs/:splat  302
+//	/chat        https://chat.example.com
+const redirectsFileName = "_redirects"
+
+// redirectStatuses are the statuses a rule in the redirects file may give.
+var redirectStatuses = []int{
+	http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+	http.StatusTemporaryRedirect, http.StatusPermanentRedirect,
+}
+
+// redirect sends requests for from to to with status. A from ending in /*
+// matches everything under it, and the part it matched replaces :splat in to.
+type redirect struct {
+	from, to string
+	status   int
+}
+
+// redirectIndex holds the redirects of the tree: the rules of the redirects file
+// followed by the aliases in the front matter of pages, which redirect to the
+// page permanently. It is built on first use and rebuilt lazily after the tree
+// changes.
+type redirectIndex struct {
+	mu    sync.Mutex
+	stale bool
+	built bool
+	// exact maps the page keys of the URLs redirected to their redirect, and
+	// prefixes holds the rules ending in /*, longest first.
+	exact    map[string]redirect
+	prefixes []redirect
+}
+
+// invalidate marks the index for rebuilding on next use.
+func (idx *redirectIndex) invalidate() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.stale = true
+}
+
+// redirectTemplate is the page written by build in place of a redirected URL,
+// as static hosts can't be told to redirect.
+var redirectTemplate = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Redirecting to {{ . }}</title>
+    <link rel="canonical" href="{{ . }}">
+    <meta http-equiv="refresh" content="0; url={{ . }}">
+</head>
+<body>
+    <p>This page has moved to <a href="{{ . }}">{{ . }}</a>.</p>
+</body>
+</html>
+`))
+
+// redirectFor returns the redirect of urlPath, a path below the mount point
+// that has no file, and the URL it redirects to.
+func (h *markdownHandler) redirectFor(urlPath string) (redirect, string, bool) {
+	h.redirects.mu.Lock()
+	if h.redirects.stale || !h.redirects.built {
+		h.redirects.exact, h.redirects.prefixes = h.readRedirects()
+		h.redirects.stale, h.redirects.built = false, true
+	}
+	exact, prefixes := h.redirects.exact, h.redirects.prefixes
+	h.redirects.mu.Unlock()
+
+	if rd, ok := exact[h.pageKey(urlPath)]; ok {
+		return rd, rd.to, true
+	}
+	urlPath = path.Clean("/" + urlPath)
+	for _, rd := range prefixes {
+		base := strings.TrimSuffix(rd.from, "/*")
+		if urlPath == base {
+			return rd, strings.ReplaceAll(rd.to, ":splat", ""), true
+		}
+		if splat, ok := strings.CutPrefix(urlPath, base+"/"); ok {
+			// Escaped, so a splat like \host can't make the target another site
+			splat = (&url.URL{Path: splat}).EscapedPath()
+			return rd, strings.ReplaceAll(rd.to, ":splat", splat), true
+		}
+	}
+	return redirect{}, "", false
+}
+
+// serveRedirect redirects r, a request for a path without a file, if there is
+// a redirect for it, keeping the query unless the target has its own. It
+// reports whether it did.
+func (h *markdownHandler) serveRedirect(w http.ResponseWriter, r *http.Request) bool {
+	rd, target, ok := h.redirectFor(r.URL.Path)
+	if !ok {
+		return false
+	}
+	if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, rd.status)
+	return true
+}
+
+// readRedirects reads the rules of the redirects file and the aliases of the
+// pages. The first redirect of a URL wins, and conflicting ones are logged.
+func (h *markdownHandler) readRedirects() (map[string]redirect, []redirect) {
+	exact := make(map[string]redirect)
+	var prefixes []redirect
+	add := func(rd redirect, origin string) {
+		if strings.HasSuffix(rd.from, "/*") {
+			prefixes = append(prefixes, rd)
+			return
+		}
+		key := h.pageKey(rd.from)
+		if existing, ok := exact[key]; ok {
+			if existing.to != rd.to {
+				log.Printf("Ignoring redirect of %s to %s in %s, it already redirects to %s\n", rd.from, rd.to, origin, existing.to)
+			}
+			return
+		}
+		exact[key] = rd
+	}
+
+	rules, err := h.readRedirectsFile()
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error reading %s: %v\n", redirectsFileName, err)
+	}
+	for _, rd := range rules {
+		add(rd, redirectsFileName)
+	}
+
+	err = h.walkMarkdown(h.basePath, func(file, rel string, _ fs.DirEntry) error {
+		fm, _, err := readMarkdown(file)
+		if err != nil || len(fm.Aliases) == 0 || !h.cfg.published(fm) {
+			return nil
+		}
+		to := h.canonicalURL(filepath.ToSlash(rel))
+		for _, alias := range fm.Aliases {
+			// Relative aliases are taken from the directory of the page
+			if !strings.HasPrefix(alias, "/") {
+				alias = path.Join("/", path.Dir(filepath.ToSlash(rel)), alias)
+			}
+			add(redirect{from: path.Clean(alias), to: to, status: http.StatusMovedPermanently}, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error indexing aliases: %v\n", err)
+	}
+
+	slices.SortStableFunc(prefixes, func(a, b redirect) int { return len(b.from) - len(a.from) })
+	return exact, prefixes
+}
+
+// readRedirectsFile parses the redirects file. Paths redirected to are within
+// the mount unless they are absolute URLs. Invalid lines are logged and skipped.
+func (h *markdownHandler) readRedirectsFile() ([]redirect, error) {
+	data, err := os.ReadFile(filepath.Join(h.basePath, redirectsFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []redirect
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		fields := strings.Fields(entry)
+		rd := redirect{status: http.StatusMovedPermanently}
+		switch len(fields) {
+		case 3:
+			status, err := strconv.Atoi(strings.TrimSuffix(fields[2], "!"))
+			if err != nil || !slices.Contains(redirectStatuses, status) {
+				log.Printf("%s:%d: unsupported status %q\n", redirectsFileName, line, fields[2])
+				continue
+			}
+			rd.status = status
+			fallthrough
+		case 2:
+			rd.from, rd.to = fields[0], fields[1]
+		default:
+			log.Printf("%s:%d: expected a path, a target and optionally a status\n", redirectsFileName, line)
+			continue
+		}
+		if !strings.HasPrefix(rd.from, "/") {
+			log.Printf("%s:%d: %q must be a path starting with /\n", redirectsFileName, line, rd.from)
+			continue
+		}
+		if strings.HasPrefix(rd.to, "/") {
+			// Links to pages take the form they have in pages, like those built to .html
+			if h.rewriteLink != nil && h.cfg.isMarkdown(rd.to) {
+				rd.to = h.rewriteLink(rd.to)
+			}
+			rd.to = h.prefix + rd.to
+		}
+		rules = append(rules, rd)
+	}
+	return rules, scanner.Err()
+}
+
+// writeRedirects writes a page redirecting to its target in place of each URL
+// redirected to outDir, unless a file is there already. Rules ending in /*
+// can't be written out and are skipped.
+func (h *markdownHandler) writeRedirects(outDir string) error {
+	exact, _ := h.readRedirects()
+	for _, rd := range exact {
+		from := rd.from
+		if ext := path.Ext(from); ext == ".html" || h.cfg.isMarkdown(from) {
+			from = htmlLink(from)
+		} else {
+			from = path.Join(from, "index.html")
+		}
+		file := filepath.Join(outDir, filepath.FromSlash(from))
+		if !isWithin(outDir, file) || fileExists(file) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := redirectTemplate.Execute(&buf, rd.to); err != nil {
+			return fmt.Errorf("writing redirect of %s: %w", rd.from, err)
+		}
+		if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package mdssr
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedirects(t *testing.T) {
+	base := t.TempDir()
+	writeTree(t, base, "kept.md")
+	writeFiles(t, base, map[string]string{
+		"docs/new.md":   "---\naliases: [/old/page.md, moved]\n---\n# New\n",
+		"docs/draft.md": "---\ndraft: true\naliases: [/old/draft]\n---\n# Draft\n",
+		// Aliases don't replace files, nor redirects from the redirects file
+		"other.md": "---\naliases: [/kept.md, /chat]\n---\n# Other\n",
+		redirectsFileName: "# Moved sections\n" +
+			"/chat https://chat.example.com\n" +
+			"/guide/* /docs/:splat 302\n" +
+			"/guide/old/* /archive/:splat\n" +
+			"/go/* /:splat\n" +
+			"/kept.md /other.md\n" +
+			"/bad /target 200\n" +
+			"relative /target\n",
+	})
+	cfg := DefaultConfig()
+	cfg.Redirects = true
+	h, err := newMarkdownHandler(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target   string
+		status   int
+		location string
+	}{
+		{"/old/page.md", http.StatusMovedPermanently, "/docs/new.md"},
+		{"/old/page", http.StatusMovedPermanently, "/docs/new.md"},
+		{"/docs/moved?a=1", http.StatusMovedPermanently, "/docs/new.md?a=1"},
+		{"/chat", http.StatusMovedPermanently, "https://chat.example.com"},
+		{"/guide/intro.md", http.StatusFound, "/docs/intro.md"},
+		{"/guide", http.StatusFound, "/docs/"},
+		{"/guide/old/v1.md", http.StatusMovedPermanently, "/archive/v1.md"},
+		// Requests can't bend a rule into pointing at another site
+		{"/guide//evil.example", http.StatusFound, "/docs/evil.example"},
+		{"/guide/%2F%2Fevil.example", http.StatusFound, "/docs/evil.example"},
+		{"/go/%5Cevil.example", http.StatusMovedPermanently, "/%5Cevil.example"},
+		{"/go/%2F%2Fevil.example", http.StatusMovedPermanently, "/evil.example"},
+		{"/guide/a%20page.md", http.StatusFound, "/docs/a%20page.md"},
+		{"/kept.md", http.StatusOK, ""},
+		{"/old/draft", http.StatusNotFound, ""},
+		{"/bad", http.StatusNotFound, ""},
+		{"/relative", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := get(h, tt.target, "")
+			if w.Code != tt.status {
+				t.Fatalf("GET %s = %d, want %d", tt.target, w.Code, tt.status)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("GET %s redirects to %q, want %q", tt.target, location, tt.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package mdssr
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// siteHandler serves requests with the current markdownHandler, which is
+// replaced as a whole when the config is reloaded.
+type siteHandler struct {
+	current atomic.Pointer[markdownHandler]
+}
+
+func (s *siteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.current.Load().ServeHTTP(w, r)
+}
+
+// contentChanged is called when files under the base path change.
+func (s *siteHandler) contentChanged() {
+	s.current.Load().contentChanged()
+}
+
+// reloadOnHangup replaces the handler of s with a new one from load whenever the
+// process receives SIGHUP. Requests in flight finish with the old handler.
+// If load fails, the old handler is kept.
+func reloadOnHangup(s *siteHandler, load func() (*markdownHandler, error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			h, err := load()
+			if err != nil {
+				log.Printf("Error reloading config, keeping the current one: %v\n", err)
+				continue
+			}
+			s.current.Store(h)
+			log.Println("Reloaded config and template")
+		}
+	}()
+}
@@ -0,0 +1,151 @@
+package mdssr
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// Default commands of the renderers that convert pages with an external tool.
+// Both are kept from reading other files, as pages may not be trusted.
+const (
+	defaultAsciidocCommand = "asciidoctor --safe-mode secure --no-header-footer --attribute showtitle --out-file - -"
+	defaultRSTCommand      = "pandoc --sandbox --from rst --to html"
+)
+
+// renderTimeout limits how long an external renderer may run for a page.
+const renderTimeout = 30 * time.Second
+
+// rendererNames are the renderers --renderers can give an extension.
+var rendererNames = []string{"markdown", "asciidoc", "rst"}
+
+// Renderer converts the source of a page, after its front matter, to HTML.
+// The TOC, summary and assets of the page are only gathered into pc by the
+// markdown renderer.
+type Renderer interface {
+	Render(w io.Writer, src []byte, pc parser.Context) error
+}
+
+// markdownRenderer renders markdown with the goldmark instance of a layout.
+type markdownRenderer struct {
+	md goldmark.Markdown
+}
+
+func (r markdownRenderer) Render(w io.Writer, src []byte, pc parser.Context) error {
+	return r.md.Convert(src, w, parser.WithContext(pc))
+}
+
+// prepareBody returns a function writing the page src rendered by r. Markdown is
+// parsed up front, so the TOC, summary and assets are in pc before the page is
+// written, as streamed pages need them for the template around the content.
+func prepareBody(r Renderer, src []byte, pc parser.Context) func(io.Writer) error {
+	if m, ok := r.(markdownRenderer); ok {
+		doc := m.md.Parser().Parse(text.NewReader(src), parser.WithContext(pc))
+		return func(w io.Writer) error { return m.md.Renderer().Render(w, src, doc) }
+	}
+	return func(w io.Writer) error { return r.Render(w, src, pc) }
+}
+
+// commandRenderer renders pages with command, split at spaces, which reads the
+// source on its standard input and writes the HTML body to its standard output.
+type commandRenderer struct {
+	command string
+}
+
+func (r commandRenderer) Render(w io.Writer, src []byte, _ parser.Context) error {
+	args := strings.Fields(r.command)
+	if len(args) == 0 {
+		return fmt.Errorf("no render command configured")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), renderTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(src), w, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// rendererFor returns the renderer of the page at path in layout l: the one
+// given its extension with WithRenderer, or else by the renderers option of the
+// directory, markdown by default. Commands come from the top-level config only.
+func (h *markdownHandler) rendererFor(l *layout, path string) Renderer {
+	ext := strings.ToLower(filepath.Ext(path))
+	if r, ok := h.renderers[ext]; ok {
+		return r
+	}
+	switch l.cfg.Renderers[ext] {
+	case "asciidoc":
+		return commandRenderer{cmp.Or(h.cfg.AsciidocCommand, defaultAsciidocCommand)}
+	case "rst":
+		return commandRenderer{cmp.Or(h.cfg.RSTCommand, defaultRSTCommand)}
+	}
+	return markdownRenderer{l.md}
+}
+
+// normalizeRenderers returns renderers with the extensions lowercased and
+// starting with a dot, checking that every renderer is one of rendererNames.
+func normalizeRenderers(renderers map[string]string) (map[string]string, error) {
+	if renderers == nil {
+		return nil, nil
+	}
+	normalized := make(map[string]string, len(renderers))
+	for ext, name := range renderers {
+		if !slices.Contains(rendererNames, name) {
+			return nil, fmt.Errorf("unknown renderer %q for %s, available renderers: %s", name, ext, strings.Join(rendererNames, ", "))
+		}
+		normalized[normalizeExt(ext)] = name
+	}
+	return normalized, nil
+}
+
+// normalizeExt returns the extension ext lowercased and starting with a dot.
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// rendererFlag is a flag.Value for a comma-separated list of extension=renderer pairs.
+type rendererFlag map[string]string
+
+func (f *rendererFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	var pairs []string
+	for _, ext := range slices.Sorted(maps.Keys(*f)) {
+		pairs = append(pairs, ext+"="+(*f)[ext])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f *rendererFlag) Set(s string) error {
+	renderers := make(map[string]string)
+	for _, pair := range parseSources(s) {
+		ext, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not an extension=renderer pair", pair)
+		}
+		renderers[ext] = name
+	}
+	*f = renderers
+	return nil
+}
@@ -0,0 +1,21 @@
+package mdssr
+
+import (
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// newSanitizer returns the policy applied to rendered markdown with --sanitize.
+// It is bluemonday's policy for user generated content, extended with what the
+// markdown extensions produce: classes, heading ids in any script, the TOC nav
+// and task list checkboxes.
+func newSanitizer() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowStyling()
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\p{L}\p{N}\-_.:]+$`)).Globally()
+	p.AllowElements("nav")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}
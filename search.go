@@ -0,0 +1,348 @@
+package mdssr
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// searchPath is the URL of the search endpoint.
+const searchPath = "/_search"
+
+// Limits for search results.
+const (
+	maxSearchResults = 50
+	snippetRadius    = 80
+)
+
+// Template for the search box shown on every page when search is enabled.
+const searchBoxTemplate = `<form class="search" action="{{ .Action }}" method="get">
+    <input type="search" name="q" value="{{ .Query }}" placeholder="Search" aria-label="Search">
+</form>`
+
+// Template for the search results page.
+const searchResultsTemplate = `<h1>{{ .Title }}</h1>
+{{- if .Results }}
+<ol class="search-results">
+    {{- range .Results }}
+    <li>
+        <a href="{{ .URL }}">{{ .Title }}</a>
+        <p>{{ .Snippet }}</p>
+    </li>
+    {{- end }}
+</ol>
+{{- else if .Query }}
+<p>No results.</p>
+{{- end }}`
+
+var (
+	searchBoxTmpl     = template.Must(template.New("searchbox").Parse(searchBoxTemplate))
+	searchResultsTmpl = template.Must(template.New("search").Parse(searchResultsTemplate))
+)
+
+// SearchResult is a page matching a search query.
+type SearchResult struct {
+	URL     string  `json:"url"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// searchDoc is an indexed markdown page.
+type searchDoc struct {
+	url   string
+	title string
+	text  string
+}
+
+// posting records how often a term occurs in a document.
+type posting struct {
+	doc   int
+	count int
+}
+
+// searchIndex is an inverted index over the markdown files in the tree.
+// It is built on first use and rebuilt lazily after the tree changes.
+type searchIndex struct {
+	root string
+	walk func(root string, fn func(path, rel string, d fs.DirEntry) error) error
+	// indexed reports whether the page at rel is searched, and url links it.
+	indexed func(rel string, fm FrontMatter) bool
+	url     func(rel string) string
+
+	mu    sync.Mutex
+	stale bool
+	docs  []searchDoc
+	terms map[string][]posting
+}
+
+func newSearchIndex(root string, walk func(root string, fn func(path, rel string, d fs.DirEntry) error) error, indexed func(rel string, fm FrontMatter) bool, url func(rel string) string) *searchIndex {
+	return &searchIndex{root: root, walk: walk, indexed: indexed, url: url, stale: true}
+}
+
+// invalidate marks the index for rebuilding on the next search.
+func (idx *searchIndex) invalidate() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.stale = true
+}
+
+// search returns the pages that contain every term in query and whose URL
+// passes allowed, best matches first.
+func (idx *searchIndex) search(query string, allowed func(url string) bool) ([]SearchResult, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.stale {
+		if err := idx.build(); err != nil {
+			return nil, err
+		}
+		idx.stale = false
+	}
+
+	queryTerms := tokenize(query)
+	if len(queryTerms) == 0 {
+		return nil, nil
+	}
+
+	scores := make(map[int]float64)
+	for i, term := range queryTerms {
+		postings := idx.terms[term]
+		idf := math.Log(1 + float64(len(idx.docs))/float64(len(postings)+1))
+		matched := make(map[int]float64)
+		for _, p := range postings {
+			// Only keep documents that matched every previous term
+			if prev, ok := scores[p.doc]; ok || i == 0 {
+				matched[p.doc] = prev + float64(p.count)*idf
+			}
+		}
+		scores = matched
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for doc, score := range scores {
+		d := idx.docs[doc]
+		if !allowed(d.url) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(d.title), strings.ToLower(query)) {
+			score *= 2
+		}
+		results = append(results, SearchResult{
+			URL:     d.url,
+			Title:   d.title,
+			Snippet: snippet(d.text, queryTerms[0]),
+			Score:   score,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].URL < results[j].URL
+	})
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+	return results, nil
+}
+
+// build walks the tree and indexes every visible markdown file.
+func (idx *searchIndex) build() error {
+	start := time.Now()
+	idx.docs = nil
+	idx.terms = make(map[string][]posting)
+
+	md := goldmark.New()
+	err := idx.walk(idx.root, func(path, rel string, _ fs.DirEntry) error {
+		fm, src, err := readMarkdown(path)
+		if err != nil {
+			log.Printf("Error indexing %s: %v\n", path, err)
+			return nil
+		}
+		if !idx.indexed(rel, fm) {
+			return nil
+		}
+
+		doc := len(idx.docs)
+		content := plainText(md, src)
+		title, ok := pageTitle(fm, src)
+		if !ok {
+			title = filepath.Base(path)
+		}
+		idx.docs = append(idx.docs, searchDoc{url: idx.url(rel), title: title, text: content})
+
+		counts := make(map[string]int)
+		for _, term := range tokenize(content) {
+			counts[term]++
+		}
+		for term, count := range counts {
+			idx.terms[term] = append(idx.terms[term], posting{doc: doc, count: count})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Indexed %d pages for search in %v\n", len(idx.docs), time.Since(start))
+	return nil
+}
+
+// plainText returns the text content of a markdown document without markup.
+func plainText(md goldmark.Markdown, src []byte) string {
+	doc := md.Parser().Parse(text.NewReader(src))
+	var b strings.Builder
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			if n.Type() == ast.TypeBlock {
+				b.WriteByte('\n')
+			}
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			b.Write(n.Segment.Value(src))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		case *ast.CodeBlock, *ast.FencedCodeBlock, *ast.HTMLBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				line := lines.At(i)
+				b.Write(line.Value(src))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return b.String()
+}
+
+// tokenize splits s into lowercase search terms.
+// Runs of letters and digits form a term; Han, Hiragana, Katakana and Hangul
+// characters are terms of their own since those scripts don't separate words.
+func tokenize(s string) []string {
+	var terms []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			terms = append(terms, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush()
+			terms = append(terms, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
+
+// snippet returns the text around the first occurrence of term.
+func snippet(content, term string) string {
+	lower := strings.ToLower(content)
+	pos := strings.Index(lower, term)
+	if pos < 0 {
+		pos = 0
+	}
+
+	start := max(pos-snippetRadius, 0)
+	end := min(pos+len(term)+snippetRadius, len(content))
+	// Don't cut multi-byte characters in half
+	for start > 0 && !utf8RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8RuneStart(content[end]) {
+		end++
+	}
+
+	s := strings.Join(strings.Fields(content[start:end]), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(content) {
+		s += "…"
+	}
+	return s
+}
+
+// utf8RuneStart reports whether b is the first byte of a UTF-8 encoded rune.
+func utf8RuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// serveSearch answers search queries as an HTML page, or as JSON when the client
+// asks for it with an Accept header or format=json.
+func (h *markdownHandler) serveSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	// Leave out the pages this visitor may not read
+	results, err := h.search.search(query, func(url string) bool { return h.allowed(r, url) })
+	if err != nil {
+		h.serverError(w, r, "Error searching")
+		log.Printf("Error building search index: %v\n", err)
+		return
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if results == nil {
+			results = []SearchResult{}
+		}
+		_ = json.NewEncoder(w).Encode(struct {
+			Query   string         `json:"query"`
+			Results []SearchResult `json:"results"`
+		}{query, results})
+		return
+	}
+
+	title := "Search"
+	if query != "" {
+		title = "Search results for " + query
+	}
+	var buf bytes.Buffer
+	err = searchResultsTmpl.Execute(&buf, struct {
+		Title   string
+		Query   string
+		Results []SearchResult
+	}{title, query, results})
+	if err != nil {
+		h.serverError(w, r, "Error rendering search results")
+		log.Printf("Error executing search template: %v\n", err)
+		return
+	}
+
+	data := PageData{Title: title, Content: template.HTML(buf.String())}
+	data.SearchBox = h.searchBox(query)
+	h.renderPage(w, r, searchPath, data, time.Time{})
+}
+
+// searchBox renders the search form, prefilled with query.
+func (h *markdownHandler) searchBox(query string) template.HTML {
+	var buf bytes.Buffer
+	_ = searchBoxTmpl.Execute(&buf, struct{ Action, Query string }{h.prefix + searchPath, query})
+	return template.HTML(buf.String())
+}
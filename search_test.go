@@ -0,0 +1,63 @@
+package mdssr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSearchResults(t *testing.T) {
+	base := t.TempDir()
+	files := map[string]string{
+		"public.md":                 "# Public\n\nneedle\n",
+		"404.md":                    "# Not found\n\nneedle\n",
+		"blog/post.md":              "---\ndate: 2024-05-01\n---\n# Post\n\nneedle\n",
+		"private/" + accessFileName: "users: [alice]\n",
+	}
+	// More private matches than a page of results, each ranking above the public ones
+	for i := range maxSearchResults + 5 {
+		files[fmt.Sprintf("private/page%d.md", i)] = "# Private\n\n" + strings.Repeat("needle ", 5) + "\n"
+	}
+	writeFiles(t, base, files)
+	cfg := DefaultConfig()
+	cfg.Search = true
+	cfg.Blog = "blog"
+	cfg.BasicAuth = []string{"alice:secret", "bob:secret"}
+	h, err := newMarkdownHandler(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(user string) []string {
+		t.Helper()
+		w := get(h, searchPath+"?q=needle&format=json", user)
+		if w.Code != http.StatusOK {
+			t.Fatalf("search as %s = %d", user, w.Code)
+		}
+		var resp struct{ Results []SearchResult }
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var urls []string
+		for _, res := range resp.Results {
+			urls = append(urls, res.URL)
+		}
+		return urls
+	}
+
+	if urls := search("bob"); !slices.Equal(urls, []string{"/blog/2024/05/post/", "/public.md"}) {
+		t.Errorf("search as bob = %v, want the post at its permalink and the public page", urls)
+	}
+	urls := search("alice")
+	if len(urls) != maxSearchResults {
+		t.Errorf("search as alice found %d pages, want %d", len(urls), maxSearchResults)
+	}
+	for _, url := range urls {
+		if !strings.HasPrefix(url, "/private/page") {
+			t.Errorf("search as alice found %s above the private pages", url)
+		}
+	}
+}
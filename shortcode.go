@@ -0,0 +1,240 @@
+package mdssr
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// shortcodeDirName is the directory of the base path holding shortcode templates.
+// The template for {{< name >}} is name.html.
+const shortcodeDirName = "_shortcodes"
+
+// shortcodeMark delimits the placeholders shortcodes are rendered as until their
+// templates are executed. It is a private use character, which sanitizing keeps.
+const shortcodeMark = "\ue000"
+
+// shortcodeName matches the names of shortcodes and their template files.
+var shortcodeName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Shortcode is the data a shortcode template is executed with.
+// For {{< figure src="cat.jpg" caption="A cat" >}}, Params holds src and caption;
+// for {{< youtube ID >}}, Args holds ID.
+type Shortcode struct {
+	Name   string
+	Args   []string
+	Params map[string]string
+}
+
+// Arg returns the i-th positional argument, or "" if there are fewer.
+func (s Shortcode) Arg(i int) string {
+	if i < 0 || i >= len(s.Args) {
+		return ""
+	}
+	return s.Args[i]
+}
+
+// shortcodesKey stores the shortcodes found while parsing a page, in order.
+var shortcodesKey = parser.NewContextKey()
+
+// KindShortcode is the node kind of shortcodes in the AST.
+var KindShortcode = ast.NewNodeKind("Shortcode")
+
+// shortcodeNode is a {{< name args >}} shortcode. It refers to the Index-th
+// shortcode stored in the parser context.
+type shortcodeNode struct {
+	ast.BaseInline
+	Index int
+}
+
+func (n *shortcodeNode) Kind() ast.NodeKind {
+	return KindShortcode
+}
+
+func (n *shortcodeNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Index": strconv.Itoa(n.Index)}, nil)
+}
+
+// shortcodeExtension parses shortcodes. They are rendered as placeholders,
+// which expandShortcodes replaces once the page has been sanitized, so their
+// templates may produce markup sanitizing would strip.
+type shortcodeExtension struct{}
+
+func (shortcodeExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(shortcodeParser{}, 150)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(shortcodeRenderer{}, 150)))
+}
+
+// shortcodeParser parses {{< name args >}} within a line.
+type shortcodeParser struct{}
+
+func (shortcodeParser) Trigger() []byte {
+	return []byte{'{'}
+}
+
+func (shortcodeParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("{{<")) {
+		return nil
+	}
+	end := bytes.Index(line, []byte(">}}"))
+	if end < 0 {
+		return nil
+	}
+	fields, ok := splitShortcodeArgs(string(line[3:end]))
+	if !ok || len(fields) == 0 || !shortcodeName.MatchString(fields[0]) {
+		return nil
+	}
+
+	code := Shortcode{Name: fields[0], Params: make(map[string]string)}
+	for _, field := range fields[1:] {
+		if key, value, ok := strings.Cut(field, "="); ok && shortcodeName.MatchString(key) {
+			code.Params[key] = value
+		} else {
+			code.Args = append(code.Args, field)
+		}
+	}
+
+	codes, _ := pc.Get(shortcodesKey).([]Shortcode)
+	pc.Set(shortcodesKey, append(codes, code))
+	block.Advance(end + 3)
+	return &shortcodeNode{Index: len(codes)}
+}
+
+// splitShortcodeArgs splits s at spaces outside double-quoted strings, unquoting them.
+// It reports false if a quote is not closed.
+func splitShortcodeArgs(s string) ([]string, bool) {
+	var fields []string
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return fields, true
+		}
+
+		var field strings.Builder
+		for s != "" && !unicode.IsSpace(rune(s[0])) {
+			if s[0] != '"' {
+				field.WriteByte(s[0])
+				s = s[1:]
+				continue
+			}
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, false
+			}
+			value, _ := strconv.Unquote(quoted)
+			field.WriteString(value)
+			s = s[len(quoted):]
+		}
+		fields = append(fields, field.String())
+	}
+}
+
+// shortcodeRenderer renders shortcodes as placeholders.
+type shortcodeRenderer struct{}
+
+func (shortcodeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindShortcode, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString(shortcodePlaceholder(n.(*shortcodeNode).Index))
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// shortcodePlaceholder returns the placeholder of the i-th shortcode of a page.
+func shortcodePlaceholder(i int) string {
+	return shortcodeMark + strconv.Itoa(i) + shortcodeMark
+}
+
+// shortcodePattern matches the placeholders of shortcodes, with the paragraph
+// around them if they are alone in one.
+var shortcodePattern = regexp.MustCompile("<p>" + shortcodeMark + `\d+` + shortcodeMark + "</p>|" + shortcodeMark + `\d+` + shortcodeMark)
+
+// expandShortcodes replaces the placeholders in content, all or part of a
+// rendered page, with the output of the templates of the shortcodes found while
+// parsing with pc. A shortcode alone in a paragraph replaces the paragraph.
+func (h *markdownHandler) expandShortcodes(content []byte, pc parser.Context) []byte {
+	codes, _ := pc.Get(shortcodesKey).([]Shortcode)
+	return shortcodePattern.ReplaceAllFunc(content, func(placeholder []byte) []byte {
+		index := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(string(placeholder), "<p>"), "</p>"), shortcodeMark)
+		i, err := strconv.Atoi(index)
+		if err != nil || i >= len(codes) {
+			return placeholder
+		}
+		code := codes[i]
+		output, err := h.executeShortcode(code)
+		if err != nil {
+			log.Printf("Error executing shortcode %s: %v\n", code.Name, err)
+			output = []byte(fmt.Sprintf("<em>Could not render shortcode %s</em>", template.HTMLEscapeString(code.Name)))
+		}
+		return output
+	})
+}
+
+// executeShortcode executes the template of code.
+func (h *markdownHandler) executeShortcode(code Shortcode) ([]byte, error) {
+	tmpl, err := h.shortcodes.lookup(filepath.Join(h.basePath, shortcodeDirName), code.Name)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, code); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// shortcodeSet caches the parsed shortcode templates until the tree changes.
+type shortcodeSet struct {
+	mu        sync.Mutex
+	templates map[string]*template.Template
+}
+
+// invalidate drops the parsed templates, so they are read again on next use.
+func (s *shortcodeSet) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates = nil
+}
+
+// lookup returns the template of the shortcode name from dir, parsing it if needed.
+func (s *shortcodeSet) lookup(dir, name string) (*template.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tmpl, ok := s.templates[name]; ok {
+		return tmpl, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, name+".html"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no template %s.html in %s", name, shortcodeDirName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(name).Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if s.templates == nil {
+		s.templates = make(map[string]*template.Template)
+	}
+	s.templates[name] = tmpl
+	return tmpl, nil
+}
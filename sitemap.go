@@ -0,0 +1,92 @@
+package mdssr
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// URLs of the generated sitemap and robots.txt.
+const (
+	sitemapPath = "/sitemap.xml"
+	robotsPath  = "/robots.txt"
+)
+
+// sitemapURL is a page listed in the sitemap.
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// urlSet is the root element of a sitemap.
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// siteOrigin returns the configured site URL, or the origin of r if there is none.
+func (h *markdownHandler) siteOrigin(r *http.Request) string {
+	if h.cfg.SiteURL != "" || r == nil {
+		return strings.TrimSuffix(h.cfg.SiteURL, "/")
+	}
+	return requestOrigin(r)
+}
+
+// sitemap lists every page that passes allowed, with the file's modification
+// time as its last modification. Error pages and unpublished pages are left out.
+func (h *markdownHandler) sitemap(origin string, allowed func(url string) bool) ([]byte, error) {
+	pages, err := h.listPages(allowed)
+	if err != nil {
+		return nil, err
+	}
+	set := urlSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, page := range pages {
+		set.URLs = append(set.URLs, sitemapURL{Loc: origin + page.URL, LastMod: page.ModTime.UTC().Format(time.DateOnly)})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// robots returns a robots.txt allowing everything but the configured paths,
+// pointing crawlers at the sitemap.
+func (h *markdownHandler) robots(origin string) []byte {
+	var b bytes.Buffer
+	b.WriteString("User-agent: *\n")
+	if len(h.cfg.RobotsDisallow) == 0 {
+		b.WriteString("Allow: /\n")
+	}
+	for _, p := range h.cfg.RobotsDisallow {
+		fmt.Fprintf(&b, "Disallow: %s\n", p)
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s%s%s\n", origin, h.prefix, sitemapPath)
+	return b.Bytes()
+}
+
+// serveSitemap serves the sitemap, listing only the pages the visitor may read.
+func (h *markdownHandler) serveSitemap(w http.ResponseWriter, r *http.Request) {
+	data, err := h.sitemap(h.siteOrigin(r), func(url string) bool { return h.allowed(r, url) })
+	if err != nil {
+		h.serverError(w, r, "Error generating sitemap")
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	_, _ = w.Write(data)
+}
+
+// serveRobots serves the generated robots.txt.
+func (h *markdownHandler) serveRobots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(h.robots(h.siteOrigin(r)))
+}
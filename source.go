@@ -0,0 +1,56 @@
+package mdssr
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Template for the source view of a page.
+const sourceTemplate = `<pre class="source"><code class="language-markdown">{{ . }}</code></pre>`
+
+var sourceTmpl = template.Must(template.New("source").Parse(sourceTemplate))
+
+// serveSourceView serves the markdown file at path unrendered if the request asks for it
+// with ?raw=1 (as text/markdown) or ?source=1 (escaped within the page template).
+// It reports whether the request was handled.
+func (h *markdownHandler) serveSourceView(w http.ResponseWriter, r *http.Request, path string, info os.FileInfo) bool {
+	query := r.URL.Query()
+	raw, source := query.Get("raw") == "1", query.Get("source") == "1"
+	if !h.cfg.Raw || !raw && !source {
+		return false
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		h.serverError(w, r, "Unable to read file")
+		log.Printf("Error reading file %s: %v\n", path, err)
+		return true
+	}
+
+	w.Header().Set("ETag", h.etag(r, path, info))
+	if raw {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		http.ServeContent(w, r, path, info.ModTime(), bytes.NewReader(src))
+		return true
+	}
+
+	var buf bytes.Buffer
+	if err := sourceTmpl.Execute(&buf, string(src)); err != nil {
+		h.serverError(w, r, "Error rendering source")
+		log.Printf("Error executing source template for %s: %v\n", path, err)
+		return true
+	}
+
+	fm, body, _ := parseFrontMatter(src)
+	title, ok := pageTitle(fm, body)
+	if !ok {
+		title = filepath.Base(path)
+	}
+	data := PageData{Title: title + " (source)", Content: template.HTML(buf.String()), dir: filepath.Dir(path)}
+	h.renderPage(w, r, path, data, h.lastModified(info))
+	return true
+}
@@ -0,0 +1,203 @@
+package mdssr
+
+import (
+	"bufio"
+	"bytes"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// streamThreshold is the size of the largest page rendered into memory and
+// cached. The HTML of larger pages is written to the client as it is rendered
+// instead, though their source and its parse tree are still held in memory,
+// which --max-page-size bounds.
+const streamThreshold = 1 << 20
+
+// streamChunkSize is the size of the chunks streamed pages are written in.
+const streamChunkSize = 32 << 10
+
+// streamMarker stands in for the content of a streamed page when executing the
+// template, which is written out in two parts around it.
+const streamMarker = "<!-- mdssr:content -->"
+
+// streams reports whether the page at path, with info, is streamed for r: when
+// it is too large to cache, unless its source, PDF or JSON are asked for.
+func (h *markdownHandler) streams(r *http.Request, info os.FileInfo) bool {
+	query := r.URL.Query()
+	switch {
+	case info.Size() <= streamThreshold:
+		return false
+	case h.cfg.Raw && (query.Get("raw") == "1" || query.Get("source") == "1"):
+		return false
+	case h.cfg.PDFCommand != "" && query.Get("format") == "pdf":
+		return false
+	case h.cfg.JSON && wantsJSON(r):
+		return false
+	}
+	return true
+}
+
+// serveStream renders the markdown file at path straight to the response: the
+// template up to the content, the content in chunks as it is rendered, then the
+// rest of the template. The source and parse tree of the page are held in
+// memory, but not its HTML, and --max-streams limits how many are rendered at
+// once. As the head is written first, pages of renderers other than markdown
+// need a title in their front matter, and the ETag doesn't cover the images
+// whose dimensions are added.
+func (h *markdownHandler) serveStream(w http.ResponseWriter, r *http.Request, path string, info os.FileInfo) {
+	if h.streamSlots != nil {
+		select {
+		case h.streamSlots <- struct{}{}:
+			defer func() { <-h.streamSlots }()
+		case <-r.Context().Done():
+			return
+		}
+	}
+	start := time.Now()
+	page, ctx, body, err := h.preparePage(h.layoutFor(filepath.Dir(path)), path, info)
+	if err != nil {
+		h.serverError(w, r, "Error rendering markdown")
+		log.Printf("Error rendering markdown %s: %v\n", path, err)
+		return
+	}
+	if !h.cfg.published(page.Meta) {
+		h.notFound(w, r)
+		return
+	}
+	if page.Title == "" {
+		page.Title = "Document"
+	}
+
+	data := page.pageData()
+	data.Content = template.HTML(streamMarker)
+	out, err := h.executeFor(r, data)
+	if err != nil {
+		h.serverError(w, r, "Error rendering page")
+		log.Printf("Error executing template for %s: %v\n", path, err)
+		return
+	}
+	head, tail, found := bytes.Cut(out, []byte(streamMarker))
+
+	if h.cfg.JSON {
+		w.Header().Add("Vary", "Accept")
+	}
+	etag := h.etag(r, path, info, page.Includes...)
+	w.Header().Set("ETag", etag)
+	modTime := h.lastModified(info)
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	if notModified(r, etag, modTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	h.setSecurityHeaders(w, data)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// Once the head is written, errors can only cut the page short
+	bw := bufio.NewWriterSize(w, streamChunkSize)
+	_, _ = bw.Write(head)
+	if found {
+		content := &contentWriter{w: bw, rewrite: func(content []byte) []byte {
+			if h.shortcodes != nil {
+				content = h.expandShortcodes(content, ctx)
+			}
+			if h.cfg.Images {
+				content, _ = h.rewriteImages(content, path)
+			}
+			return content
+		}}
+		if err := h.writeBody(content, body); err != nil {
+			log.Printf("Error streaming markdown %s: %v\n", path, err)
+			return
+		}
+		_ = content.Flush()
+		_, _ = bw.Write(tail)
+	}
+	if err := bw.Flush(); err != nil {
+		log.Printf("Error streaming markdown %s: %v\n", path, err)
+	}
+	metrics.observeRender(time.Since(start))
+}
+
+// writeBody writes the content of a page with body to w, through the sanitizer
+// with --sanitize.
+func (h *markdownHandler) writeBody(w io.Writer, body func(io.Writer) error) error {
+	if h.sanitize == nil {
+		return body(w)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(body(pw))
+	}()
+	err := h.sanitize.SanitizeReaderToWriter(pr, w)
+	// Stops the renderer if the sanitizer gave up early
+	pr.CloseWithError(err)
+	return err
+}
+
+// contentWriter passes the content of a streamed page through rewrite on its
+// way to w, as loadPage does with the whole content. It holds back content
+// until a line ends outside of a tag, so that tags and shortcode placeholders,
+// which the markdown renderer doesn't break across lines, are rewritten whole.
+// Lines longer than streamChunkSize are cut after a tag instead.
+type contentWriter struct {
+	w       io.Writer
+	rewrite func([]byte) []byte
+	buf     []byte
+}
+
+func (c *contentWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	end := bytes.LastIndexByte(c.buf, '\n') + 1
+	if end == 0 && len(c.buf) > streamChunkSize {
+		end = bytes.LastIndexByte(c.buf, '>') + 1
+	}
+	// A tag spanning lines is held back from its start
+	if lt := bytes.LastIndexByte(c.buf[:end], '<'); lt > bytes.LastIndexByte(c.buf[:end], '>') {
+		end = lt
+	}
+	if end > 0 {
+		if _, err := c.w.Write(c.rewrite(c.buf[:end])); err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf[:0], c.buf[end:]...)
+	}
+	return len(p), nil
+}
+
+// Flush writes out the content held back.
+func (c *contentWriter) Flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	_, err := c.w.Write(c.rewrite(c.buf))
+	c.buf = c.buf[:0]
+	return err
+}
+
+// notModified reports whether the conditional request r can be answered with
+// 304 for a response with etag, last modified at modTime.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modTime.Truncate(time.Second).After(since)
+}
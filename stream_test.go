@@ -0,0 +1,63 @@
+package mdssr
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServeStream(t *testing.T) {
+	var dot bytes.Buffer
+	if err := png.Encode(&dot, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	page := "{{< greet World >}}\n\n![dot](dot.png)\n\n"
+	filler := strings.Repeat("Filler text long enough to make the page larger than the stream threshold.\n\n", streamThreshold/64)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"dot.png":                        dot.String(),
+		shortcodeDirName + "/greet.html": `<strong class="greeting">Hello, {{.Arg 0}}</strong>`,
+		"small.md":                       "Small Title\n===========\n\n" + page,
+		"large.md":                       "Large Title\n===========\n\n" + page + filler + page,
+		"untitled.md":                    page + filler,
+	})
+	cfg := DefaultConfig()
+	cfg.Shortcodes = true
+	for _, maxStreams := range []int{0, 1} {
+		cfg.MaxStreams = maxStreams
+		h, err := New(base, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			path  string
+			title string
+			count int
+		}{
+			{"/small.md", "Small Title", 1},
+			{"/large.md", "Large Title", 2},
+			{"/untitled.md", "Document", 1},
+		}
+		for _, tt := range tests {
+			w := get(h, tt.path, "")
+			body := w.Body.String()
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s with --max-streams %d = %d", tt.path, maxStreams, w.Code)
+			}
+			if !strings.Contains(body, "<title>"+tt.title+"</title>") {
+				t.Errorf("GET %s with --max-streams %d isn't titled %s", tt.path, maxStreams, tt.title)
+			}
+			for _, want := range []string{`<strong class="greeting">Hello, World</strong>`, `width="3" height="2"`} {
+				if got := strings.Count(body, want); got != tt.count {
+					t.Errorf("GET %s with --max-streams %d has %d of %s, want %d", tt.path, maxStreams, got, want, tt.count)
+				}
+			}
+			if strings.Contains(body, shortcodeMark) {
+				t.Errorf("GET %s with --max-streams %d has shortcode placeholders left", tt.path, maxStreams)
+			}
+		}
+	}
+}
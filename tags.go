@@ -0,0 +1,202 @@
+package mdssr
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Template for the index of the terms of a taxonomy.
+const termsTemplate = `<ul class="tags">
+    {{- range . }}
+    <li><a href="{{ .URL }}">{{ .Name }}</a> <span class="count">{{ .Count }}</span></li>
+    {{- end }}
+</ul>`
+
+var termsTmpl = template.Must(template.New("terms").Parse(termsTemplate))
+
+// taxonomy groups pages by a list in their front matter.
+type taxonomy struct {
+	// path is the URL of the taxonomy's index; each term is listed below it.
+	path string
+	// plural and singular name the taxonomy in page titles.
+	plural, singular string
+	terms            func(pageSummary) []string
+}
+
+var taxonomies = []taxonomy{
+	{path: "/tags/", plural: "Tags", singular: "Tag", terms: func(p pageSummary) []string { return p.Tags }},
+	{path: "/categories/", plural: "Categories", singular: "Category", terms: func(p pageSummary) []string { return p.Categories }},
+}
+
+// Tag is a tag or category and the number of pages that have it.
+type Tag struct {
+	Name  string
+	URL   string
+	Count int
+}
+
+// taxonomyTerm is a tag or category and the pages that have it, newest first.
+type taxonomyTerm struct {
+	name  string
+	pages []pageSummary
+}
+
+// taxonomyIndex maps the slug of each term of each taxonomy to its pages.
+// It is built on first use and rebuilt lazily after the tree changes.
+type taxonomyIndex struct {
+	mu    sync.Mutex
+	stale bool
+	// terms is indexed like taxonomies. The maps aren't modified once built.
+	terms []map[string]*taxonomyTerm
+}
+
+// invalidate marks the index for rebuilding on next use.
+func (t *taxonomyIndex) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stale = true
+}
+
+// taxonomyTerms returns the terms of every taxonomy, building the index if needed.
+func (h *markdownHandler) taxonomyTerms() []map[string]*taxonomyTerm {
+	h.tags.mu.Lock()
+	defer h.tags.mu.Unlock()
+	if h.tags.stale || h.tags.terms == nil {
+		terms, err := h.buildTaxonomies()
+		if err != nil {
+			log.Printf("Error indexing tags: %v\n", err)
+		}
+		h.tags.terms = terms
+		h.tags.stale = false
+	}
+	return h.tags.terms
+}
+
+// buildTaxonomies groups all pages in the tree by their terms.
+// Terms are told apart by their slug, and named by their first spelling seen.
+func (h *markdownHandler) buildTaxonomies() ([]map[string]*taxonomyTerm, error) {
+	terms := make([]map[string]*taxonomyTerm, len(taxonomies))
+	for i := range terms {
+		terms[i] = make(map[string]*taxonomyTerm)
+	}
+
+	pages, err := h.listPages(func(string) bool { return true })
+	for _, page := range pages {
+		for i, tax := range taxonomies {
+			for _, name := range tax.terms(page) {
+				slug := slugify(name)
+				if slug == "" {
+					continue
+				}
+				term, ok := terms[i][slug]
+				if !ok {
+					term = &taxonomyTerm{name: strings.TrimSpace(name)}
+					terms[i][slug] = term
+				}
+				// A page may list a term twice under different spellings
+				if n := len(term.pages); n == 0 || term.pages[n-1].URL != page.URL {
+					term.pages = append(term.pages, page)
+				}
+			}
+		}
+	}
+	return terms, err
+}
+
+// tagCloud returns the terms of the i-th taxonomy sorted by name, counting the
+// pages that pass allowed. Terms without such pages are left out.
+func (h *markdownHandler) tagCloud(i int, allowed func(url string) bool) []Tag {
+	tags := []Tag{}
+	for slug, term := range h.taxonomyTerms()[i] {
+		count := 0
+		for _, page := range term.pages {
+			if allowed(page.URL) {
+				count++
+			}
+		}
+		if count > 0 {
+			tags = append(tags, Tag{Name: term.name, URL: h.prefix + taxonomies[i].path + slug + "/", Count: count})
+		}
+	}
+	sort.Slice(tags, func(a, b int) bool {
+		return strings.ToLower(tags[a].Name) < strings.ToLower(tags[b].Name)
+	})
+	return tags
+}
+
+// serveTaxonomy serves the index of each taxonomy and the listing of each term.
+// It reports whether urlPath belonged to a taxonomy.
+func (h *markdownHandler) serveTaxonomy(w http.ResponseWriter, r *http.Request) bool {
+	urlPath := r.URL.Path
+	for i, tax := range taxonomies {
+		// Make sure relative links resolve inside the listings
+		if urlPath+"/" == tax.path {
+			h.redirect(w, r, tax.path)
+			return true
+		}
+		rest, ok := strings.CutPrefix(urlPath, tax.path)
+		if !ok {
+			continue
+		}
+		slug, ok := strings.CutSuffix(rest, "/")
+		if !ok && rest != "" {
+			if h.taxonomyTerms()[i][slug] != nil {
+				h.redirect(w, r, urlPath+"/")
+				return true
+			}
+			return false
+		}
+
+		allowed := func(url string) bool { return h.allowed(r, url) }
+		data, ok, err := h.taxonomyPage(i, slug, allowed)
+		if err != nil {
+			log.Printf("Error rendering %s: %v\n", urlPath, err)
+			h.serverError(w, r, "Error rendering "+strings.ToLower(tax.plural))
+			return true
+		}
+		if !ok {
+			return false
+		}
+		h.renderPage(w, r, urlPath, data, time.Time{})
+		return true
+	}
+	return false
+}
+
+// taxonomyPage returns the data of the listing of the term with the given slug
+// in the i-th taxonomy, or of the taxonomy's index if slug is empty.
+// It reports whether there is such a term.
+func (h *markdownHandler) taxonomyPage(i int, slug string, allowed func(url string) bool) (PageData, bool, error) {
+	tax := taxonomies[i]
+	var buf bytes.Buffer
+	if slug == "" {
+		if err := termsTmpl.Execute(&buf, h.tagCloud(i, allowed)); err != nil {
+			return PageData{}, false, err
+		}
+		return PageData{Title: tax.plural, Content: template.HTML(buf.String())}, true, nil
+	}
+
+	term, ok := h.taxonomyTerms()[i][slug]
+	if !ok {
+		return PageData{}, false, nil
+	}
+	var listing postListing
+	for _, page := range term.pages {
+		if allowed(page.URL) {
+			listing.Posts = append(listing.Posts, page)
+		}
+	}
+	if len(listing.Posts) == 0 {
+		return PageData{}, false, nil
+	}
+	if err := blogTmpl.Execute(&buf, listing); err != nil {
+		return PageData{}, false, err
+	}
+	return PageData{Title: tax.singular + ": " + term.name, Content: template.HTML(buf.String())}, true, nil
+}
@@ -0,0 +1,109 @@
+package mdssr
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// templateFuncs are the functions page templates and partials can call:
+//
+//	markdownify renders a string of markdown, like a front matter param, as HTML,
+//	            without the paragraph around a single one;
+//	relURL      returns the URL of a path on the site, below the base URL and mount;
+//	now         returns the current time.
+func (h *markdownHandler) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"markdownify": h.markdownify,
+		"relURL":      h.relURL,
+		"now":         time.Now,
+	}
+}
+
+// markdownify renders md as HTML with the options of the site.
+func (h *markdownHandler) markdownify(md string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := h.md.Convert([]byte(md), &buf); err != nil {
+		return "", err
+	}
+	content := buf.Bytes()
+	if h.sanitize != nil {
+		content = h.sanitize.SanitizeBytes(content)
+	}
+	// Inline markdown shouldn't break the line it's used in
+	trimmed := bytes.TrimSpace(content)
+	if inner, ok := bytes.CutPrefix(trimmed, []byte("<p>")); ok && bytes.HasSuffix(inner, []byte("</p>")) && bytes.Count(trimmed, []byte("<p>")) == 1 {
+		content = bytes.TrimSuffix(inner, []byte("</p>"))
+	}
+	return template.HTML(content), nil
+}
+
+// relURL returns the URL of the path p on the site. Absolute URLs and fragments
+// are returned as they are.
+func (h *markdownHandler) relURL(p string) string {
+	if u, err := url.Parse(p); err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(p, "#") {
+		return p
+	}
+	return h.prefix + "/" + strings.TrimPrefix(p, "/")
+}
+
+// loadTemplates parses tmplText as the page template of the handler, along
+// with the partials of --template-dir, which every page template can use.
+func (h *markdownHandler) loadTemplates(tmplText string) error {
+	h.partials = template.New("").Funcs(h.templateFuncs())
+	if h.cfg.TemplateDir != "" {
+		entries, err := os.ReadDir(h.cfg.TemplateDir)
+		if err != nil {
+			return err
+		}
+		hash := fnv.New64a()
+		for _, entry := range entries {
+			name, ok := strings.CutSuffix(entry.Name(), ".html")
+			if !ok || entry.IsDir() {
+				continue
+			}
+			if name == "page" {
+				return fmt.Errorf("%s: page.html would replace the page template", h.cfg.TemplateDir)
+			}
+			data, err := os.ReadFile(filepath.Join(h.cfg.TemplateDir, entry.Name()))
+			if err != nil {
+				return err
+			}
+			if _, err := h.partials.New(name).Parse(string(data)); err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s\x00%s\x00", name, data)
+		}
+		h.partialsVersion = hash.Sum64()
+	}
+
+	tmpl, err := h.parseTemplate(tmplText)
+	if err != nil {
+		return err
+	}
+	h.tmpl, h.tmplText = tmpl, tmplText
+	h.version = h.templateVersion(tmplText, h.cfg)
+	return nil
+}
+
+// parseTemplate parses text as a page template, with the template funcs and
+// the partials.
+func (h *markdownHandler) parseTemplate(text string) (*template.Template, error) {
+	set, err := h.partials.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return set.New("page").Parse(text)
+}
+
+// templateVersion is the version of the pages rendered with the page template
+// text and cfg, which also changes with the partials.
+func (h *markdownHandler) templateVersion(text string, cfg Config) uint64 {
+	return handlerVersion(text, cfg) ^ h.partialsVersion
+}
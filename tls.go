@@ -0,0 +1,61 @@
+package mdssr
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsEnabled reports whether the options ask for HTTPS.
+func (c Config) tlsEnabled() bool {
+	return c.TLSCert != "" || c.TLSKey != "" || len(c.Autocert) > 0
+}
+
+// configureTLS sets up server to use the configured certificate, or to obtain
+// certificates for the autocert domains.
+func configureTLS(server *http.Server, cfg Config) error {
+	switch {
+	case len(cfg.Autocert) > 0:
+		if cfg.TLSCert != "" || cfg.TLSKey != "" {
+			return errors.New("--autocert can't be combined with --tls-cert and --tls-key")
+		}
+		cacheDir, err := autocertCacheDir(cfg.AutocertCache)
+		if err != nil {
+			return err
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Autocert...),
+			Cache:      autocert.DirCache(cacheDir),
+		}
+		// Certificates are obtained with the TLS-ALPN challenge on the listen address
+		server.TLSConfig = m.TLSConfig()
+	case cfg.TLSCert == "" || cfg.TLSKey == "":
+		return errors.New("--tls-cert and --tls-key must be given together")
+	default:
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return nil
+}
+
+// autocertCacheDir returns the directory to keep certificates in, defaulting
+// to a directory in the user's cache directory.
+func autocertCacheDir(dir string) (string, error) {
+	return userCacheDir(dir, "autocert")
+}
+
+// userCacheDir returns dir, or if it is empty, mdssr/name in the user cache directory.
+func userCacheDir(dir, name string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "mdssr", name), nil
+}
@@ -0,0 +1,190 @@
+package mdssr
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// tocMarker is the paragraph text that is replaced by the table of contents.
+const tocMarker = "[TOC]"
+
+// tocKey stores the rendered table of contents in the parser context.
+var tocKey = parser.NewContextKey()
+
+// KindTOC is the node kind of a [TOC] marker in the AST.
+var KindTOC = ast.NewNodeKind("TOC")
+
+// tocNode is a block that renders a pre-built table of contents.
+type tocNode struct {
+	ast.BaseBlock
+	html template.HTML
+}
+
+func (n *tocNode) Kind() ast.NodeKind {
+	return KindTOC
+}
+
+func (n *tocNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// tocExtension gives every heading an id, collects the headings into a table of contents,
+// and renders it in place of any [TOC] paragraph.
+type tocExtension struct{}
+
+func (tocExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(tocTransformer{}, 200)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(tocRenderer{}, 200)))
+}
+
+// tocTransformer builds the table of contents once the document has been parsed.
+type tocTransformer struct{}
+
+// tocHeading is a heading collected for the table of contents.
+type tocHeading struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+func (tocTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var headings []tocHeading
+	var markers []ast.Node
+
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			id, _ := n.AttributeString("id")
+			idBytes, _ := id.([]byte)
+			headings = append(headings, tocHeading{Level: n.Level, ID: string(idBytes), Text: string(n.Text(source))})
+			return ast.WalkSkipChildren, nil
+		case *ast.Paragraph:
+			lines := n.Lines()
+			if lines.Len() == 1 {
+				line := lines.At(0)
+				if string(bytes.TrimSpace(line.Value(source))) == tocMarker {
+					markers = append(markers, n)
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	toc := renderTOC(headings)
+	pc.Set(tocKey, toc)
+	for _, marker := range markers {
+		marker.Parent().ReplaceChild(marker.Parent(), marker, &tocNode{html: toc})
+	}
+}
+
+// tocRenderer writes tocNode blocks.
+type tocRenderer struct{}
+
+func (tocRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindTOC, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString(string(n.(*tocNode).html))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// renderTOC renders headings as nested lists of links to their ids.
+func renderTOC(headings []tocHeading) template.HTML {
+	if len(headings) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(`<nav class="toc">`)
+	var levels []int
+	for _, h := range headings {
+		// Close lists that are deeper than this heading
+		for len(levels) > 0 && levels[len(levels)-1] > h.Level {
+			b.WriteString("</li></ul>")
+			levels = levels[:len(levels)-1]
+		}
+		if len(levels) > 0 && levels[len(levels)-1] == h.Level {
+			b.WriteString("</li>")
+		} else {
+			b.WriteString("<ul>")
+			levels = append(levels, h.Level)
+		}
+		fmt.Fprintf(&b, `<li><a href="#%s">%s</a>`, html.EscapeString(h.ID), html.EscapeString(h.Text))
+	}
+	for range levels {
+		b.WriteString("</li></ul>")
+	}
+	b.WriteString("</nav>")
+	return template.HTML(b.String())
+}
+
+// tocFromContext returns the table of contents built while parsing with pc.
+func tocFromContext(pc parser.Context) template.HTML {
+	toc, _ := pc.Get(tocKey).(template.HTML)
+	return toc
+}
+
+// slugIDs generates heading ids that keep non-ASCII letters, so headings
+// in any language get readable anchors. Duplicates get a numeric suffix.
+type slugIDs struct {
+	values map[string]bool
+}
+
+func newSlugIDs() *slugIDs {
+	return &slugIDs{values: make(map[string]bool)}
+}
+
+func (s *slugIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	slug := slugify(string(value))
+	if slug == "" {
+		slug = "heading"
+	}
+	id := slug
+	for i := 1; s.values[id]; i++ {
+		id = fmt.Sprintf("%s-%d", slug, i)
+	}
+	s.values[id] = true
+	return []byte(id)
+}
+
+func (s *slugIDs) Put(value []byte) {
+	s.values[string(value)] = true
+}
+
+// slugify lowercases s, keeps letters and digits, and joins words with dashes.
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,157 @@
+package mdssr
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Paths served by the live reload support.
+const (
+	liveReloadPath   = "/_livereload"
+	liveReloadScript = "/_livereload.js"
+)
+
+// Client script that reloads the page whenever the server reports a change.
+const liveReloadJS = `(function () {
+    var source = new EventSource(document.currentScript.src.replace(/\.js$/, ""));
+    source.onmessage = function () {
+        location.reload();
+    };
+})();
+`
+
+// watchDebounce is how long the tree must be quiet before a change is reported.
+// Editors usually produce several events for a single save.
+const watchDebounce = 100 * time.Millisecond
+
+// watchTree watches root and all of its subdirectories and calls onChange after files change.
+// Directories created later are watched as well.
+func watchTree(root string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := addWatchDirs(watcher, root); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Create) {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						if err := addWatchDirs(watcher, event.Name); err != nil {
+							slog.Warn("Unable to watch directory", "path", event.Name, "error", err)
+						}
+					}
+				}
+				if timer == nil {
+					timer = time.AfterFunc(watchDebounce, onChange)
+				} else {
+					timer.Reset(watchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("File watcher error", "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// addWatchDirs adds dir and its visible subdirectories to watcher.
+func addWatchDirs(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// liveReload notifies connected browsers over server-sent events when the content changes.
+type liveReload struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+func newLiveReload() *liveReload {
+	return &liveReload{clients: make(map[chan struct{}]struct{})}
+}
+
+// broadcast tells every connected browser to reload.
+func (lr *liveReload) broadcast() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for client := range lr.clients {
+		select {
+		case client <- struct{}{}:
+		default:
+			// A reload is already pending for this client
+		}
+	}
+}
+
+// ServeHTTP serves the event stream at liveReloadPath and the client script at liveReloadScript.
+func (lr *liveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == liveReloadScript {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		fmt.Fprint(w, liveReloadJS)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	client := make(chan struct{}, 1)
+	lr.mu.Lock()
+	lr.clients[client] = struct{}{}
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, client)
+		lr.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-client:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package mdssr
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindWikiLink is the node kind of [[wiki links]] in the AST.
+var KindWikiLink = ast.NewNodeKind("WikiLink")
+
+// wikiLink is a [[Page Name]] or [[Page Name|label]] link.
+type wikiLink struct {
+	ast.BaseInline
+	Label   string
+	URL     string
+	Missing bool
+}
+
+func (n *wikiLink) Kind() ast.NodeKind {
+	return KindWikiLink
+}
+
+func (n *wikiLink) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Label": n.Label, "URL": n.URL}, nil)
+}
+
+// wikiLinkExtension turns [[Page Name]] into links to the page of that name.
+// resolve returns the URL of the page with the given name and whether it exists.
+type wikiLinkExtension struct {
+	resolve func(name string) (string, bool)
+}
+
+func (e wikiLinkExtension) Extend(m goldmark.Markdown) {
+	// Ahead of the link parser, which also triggers on [
+	m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(wikiLinkParser(e), 150)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(wikiLinkRenderer{}, 150)))
+}
+
+// wikiLinkParser parses [[target]], [[target|label]] and [[target#heading]] within a line.
+type wikiLinkParser wikiLinkExtension
+
+func (wikiLinkParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (p wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if len(line) < 4 || line[1] != '[' {
+		return nil
+	}
+	end := strings.Index(string(line[2:]), "]]")
+	if end <= 0 {
+		return nil
+	}
+	inner := string(line[2 : 2+end])
+	if strings.ContainsAny(inner, "[]") {
+		return nil
+	}
+
+	target, label, ok := strings.Cut(inner, "|")
+	target = strings.TrimSpace(target)
+	if !ok {
+		label = target
+	}
+	name, heading, _ := strings.Cut(target, "#")
+
+	link := &wikiLink{Label: strings.TrimSpace(label)}
+	if name = strings.TrimSpace(name); name != "" {
+		url, ok := p.resolve(name)
+		link.URL, link.Missing = url, !ok
+	}
+	if heading != "" {
+		link.URL += "#" + slugify(heading)
+	}
+	block.Advance(end + 4)
+	return link
+}
+
+// wikiLinkRenderer renders wiki links with the "wikilink" class, and the
+// "missing" class for pages that don't exist.
+type wikiLinkRenderer struct{}
+
+func (wikiLinkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindWikiLink, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		link := n.(*wikiLink)
+		class := "wikilink"
+		if link.Missing {
+			class += " missing"
+		}
+		_, _ = fmt.Fprintf(w, `<a class="%s" href="%s">`, class, util.EscapeHTML(util.URLEscape([]byte(link.URL), false)))
+		_, _ = w.Write(util.EscapeHTML([]byte(link.Label)))
+		_, _ = w.WriteString("</a>")
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// wikiIndex maps page names to the markdown files in the tree.
+// It is built on first use and rebuilt lazily after the tree changes.
+type wikiIndex struct {
+	mu    sync.Mutex
+	stale bool
+	pages map[string]string
+	// version changes whenever the set of pages does
+	version uint64
+}
+
+// invalidate marks the index for rebuilding on next use.
+func (idx *wikiIndex) invalidate() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.stale = true
+}
+
+// wikiKey normalizes a page name so that "Getting Started", "getting-started"
+// and "getting_started" name the same page.
+func wikiKey(name string) string {
+	name = strings.ToLower(strings.Trim(strings.TrimSpace(name), "/"))
+	return strings.NewReplacer(" ", "-", "_", "-").Replace(name)
+}
+
+// wikiPages returns the index of page names, building it if needed, and its version.
+func (h *markdownHandler) wikiPages() (map[string]string, uint64) {
+	h.wiki.mu.Lock()
+	defer h.wiki.mu.Unlock()
+	if h.wiki.stale || h.wiki.pages == nil {
+		h.wiki.pages = h.buildWikiIndex()
+		h.wiki.version = wikiVersion(h.wiki.pages)
+		h.wiki.stale = false
+	}
+	return h.wiki.pages, h.wiki.version
+}
+
+// buildWikiIndex maps the path of every visible markdown file, relative to the base
+// path and without the extension, to the file. Pages can also be named by their
+// file name alone, and directories by the name of their index page's directory.
+// Where names clash, the page closest to the root wins.
+func (h *markdownHandler) buildWikiIndex() map[string]string {
+	pages := make(map[string]string)
+	var names []string
+	err := h.walkMarkdown(h.basePath, func(file, rel string, _ fs.DirEntry) error {
+		// Links to unpublished pages are shown as missing
+		if fm, _, err := readMarkdown(file); err == nil && !h.cfg.published(fm) {
+			return nil
+		}
+		page := h.cfg.pageName(rel)
+		pages[wikiKey(page)] = rel
+		if dir := path.Dir(page); dir != "." && h.isIndexFile(file) {
+			dir += "/"
+			pages[wikiKey(dir)] = rel
+			names = append(names, dir)
+		} else {
+			names = append(names, page)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error indexing wiki pages: %v\n", err)
+	}
+
+	// Shorter paths first, so they win over deeper pages with the same name
+	sort.SliceStable(names, func(i, j int) bool {
+		return strings.Count(names[i], "/") < strings.Count(names[j], "/")
+	})
+	for _, name := range names {
+		key := wikiKey(path.Base(strings.TrimSuffix(name, "/")))
+		if _, ok := pages[key]; !ok {
+			pages[key] = pages[wikiKey(name)]
+		}
+	}
+	return pages
+}
+
+// wikiVersion hashes the index so validators of pages change with it.
+func wikiVersion(pages map[string]string) uint64 {
+	keys := make([]string, 0, len(pages))
+	for key := range pages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := fnv.New64a()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s\x00%s\x00", key, pages[key])
+	}
+	return hash.Sum64()
+}
+
+// resolveWikiLink returns the URL of the page called name and whether it exists.
+// Links to missing pages point at the configured creation URL, the editor of the
+// page with --editable, or else at where the page would be.
+func (h *markdownHandler) resolveWikiLink(name string) (string, bool) {
+	pages, _ := h.wikiPages()
+	if rel, ok := pages[wikiKey(name)]; ok {
+		return h.pageURL(rel), true
+	}
+
+	if h.cfg.WikiCreate != "" {
+		u, err := url.Parse(h.cfg.WikiCreate)
+		if err == nil {
+			query := u.Query()
+			query.Set("page", name)
+			u.RawQuery = query.Encode()
+			return u.String(), false
+		}
+	}
+	rel := strings.Trim(name, "/") + h.cfg.MarkdownExts[0]
+	if h.cfg.Editable {
+		return h.prefix + editPath + (&url.URL{Path: rel}).EscapedPath(), false
+	}
+	return h.pageURL(rel), false
+}